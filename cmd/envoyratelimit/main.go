@@ -0,0 +1,95 @@
+// Copyright (c) Microsoft Corporation. All rights reserved.
+// Licensed under the MIT License. See License.txt in the project root for license information.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/go-redis/redis/v8"
+	"google.golang.org/grpc"
+
+	"github.com/Azure/rate-limiter/pkg/cache"
+	"github.com/Azure/rate-limiter/pkg/envoyrls"
+	"github.com/Azure/rate-limiter/ratelimiter"
+)
+
+const (
+	memoryCacheDefaultExpireTime = 600 * time.Second
+	memoryCacheDefaultPurgeTime  = 1200 * time.Second
+)
+
+// buildRemoteCacheClient connects to a redis cluster when REDIS_HOST has more than one address, otherwise a single redis
+func buildRemoteCacheClient(ctx context.Context, redisHost, redisPassword string) (cache.CacheClient, error) {
+	addrs := strings.Split(redisHost, ",")
+	if len(addrs) > 1 {
+		client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: addrs, Password: redisPassword})
+		if err := client.Ping(ctx).Err(); err != nil {
+			return nil, fmt.Errorf("failed to connect with redis cluster at %s - %v", redisHost, err)
+		}
+		return cache.NewClusterClient(client), nil
+	}
+	client := redis.NewClient(&redis.Options{Addr: redisHost, Password: redisPassword})
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect with redis instance at %s - %v", redisHost, err)
+	}
+	return cache.NewRedisClient(ctx, client), nil
+}
+
+func main() {
+	port := flag.Int("port", 8081, "grpc port of the rate limit service")
+	flag.Parse()
+	configPaths := flag.Args()
+	if len(configPaths) == 0 {
+		log.Fatal("usage: envoyratelimit [-port 8081] <domain config file>...")
+	}
+	ctx := context.Background()
+
+	config, err := envoyrls.LoadConfig(configPaths...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	memClient := cache.NewMemCacheClient(memoryCacheDefaultExpireTime, memoryCacheDefaultPurgeTime)
+	var rateLimiter *ratelimiter.TokenBucketRateLimiter
+	redisHost := os.Getenv("REDIS_HOST")
+	if len(redisHost) == 0 {
+		log.Println("REDIS_HOST is not set, will use in memory cache only")
+		rateLimiter = ratelimiter.NewTokenBucketRateLimiter(memClient, nil)
+	} else {
+		remoteClient, err := buildRemoteCacheClient(ctx, redisHost, os.Getenv("REDIS_PASSWORD"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		rateLimiter = ratelimiter.NewTokenBucketRateLimiter(memClient, remoteClient)
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := grpc.NewServer()
+	rlsv3.RegisterRateLimitServiceServer(server, envoyrls.NewServer(rateLimiter, config))
+	go func() {
+		log.Printf("Start rate limit service on port %d", *port)
+		if err := server.Serve(listener); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	// listening to OS shutdown signal
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	<-signalChan
+	log.Println("Got shutdown signal, shutting down server gracefully...")
+	server.GracefulStop()
+}
@@ -3,12 +3,11 @@ package handlers
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 
 	"github.com/Azure/rate-limiter/pkg/algorithm"
+	"github.com/Azure/rate-limiter/pkg/httpmiddleware"
 	"github.com/Azure/rate-limiter/ratelimiter"
 	"github.com/gorilla/mux"
 )
@@ -27,35 +26,10 @@ func NewClusterCreateRequestHandlers(ctx context.Context, ratelimiter ratelimite
 	}
 }
 
+// HandleRequest creates the cluster, requests are rate limited by httpmiddleware before reaching here
 func (uh ClusterCreateRequestHandlers) HandleRequest(rw http.ResponseWriter, r *http.Request) {
-	payload, err := ioutil.ReadAll(r.Body)
-
-	if err != nil {
-		http.Error(rw, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	var u map[string]interface{}
-	err = json.Unmarshal([]byte(payload), &u)
-	if err != nil {
-		http.Error(rw, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	_, found := u[uh.key]
-	if !found {
-		http.Error(rw, fmt.Sprintf("can't find key %s in request", uh.key), http.StatusInternalServerError)
-		return
-	}
-	id := u[uh.key].(string)
-	log.Printf("find bucket by key: %s\n", id)
-	result, err := uh.ratelimiter.GetDecision(uh.ctx, id, algorithm.DefaultBurstSize, algorithm.DefaultTokenDropRate)
-	if err != nil {
-		log.Printf("failed to get decision from remote cache: %s", err)
-	}
-	if !result.Allowed {
-		http.Error(rw, fmt.Sprintf("too many requests, retry after %s", result.RetryAfter), http.StatusTooManyRequests)
-		return
+	if decision, ok := httpmiddleware.DecisionFromContext(r.Context()); ok {
+		log.Printf("request allowed, %d tokens remaining\n", decision.Remaining)
 	}
 	rw.WriteHeader(http.StatusCreated)
 }
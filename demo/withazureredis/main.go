@@ -14,7 +14,9 @@ import (
 	"time"
 
 	"github.com/Azure/rate-limiter/demo/handlers"
+	"github.com/Azure/rate-limiter/pkg/algorithm"
 	"github.com/Azure/rate-limiter/pkg/cache"
+	"github.com/Azure/rate-limiter/pkg/httpmiddleware"
 	"github.com/Azure/rate-limiter/ratelimiter"
 	"github.com/gorilla/mux"
 )
@@ -41,7 +43,7 @@ func main() {
 		log.Fatal("MSI_OBJECT_ID is not set.")
 	}
 
-	var uh handlers.ClusterCreateRequestHandlers
+	var rateLimiter *ratelimiter.TokenBucketRateLimiter
 
 	memClient := cache.NewMemCacheClient(memoryCacheDefaultExpireTime, memoryCacheDefaultPurgeTime)
 
@@ -49,14 +51,16 @@ func main() {
 	redisCacheClient, err := cache.NewAzureRedisClient(ctx, fmt.Sprintf("%s.redis.cache.windows.net", redisName), 6380, msiObjectID)
 	if err != nil {
 		log.Printf("Fail to build redis client from azure, will use in memory cache instead error: %s", err.Error())
-		uh = handlers.NewClusterCreateRequestHandlers(ctx, *ratelimiter.NewTokenBucketRateLimiter(memClient, nil), key)
+		rateLimiter = ratelimiter.NewTokenBucketRateLimiter(memClient, nil)
 	} else {
 		log.Println("Finish build redis client from azure")
-		uh = handlers.NewClusterCreateRequestHandlers(ctx, *ratelimiter.NewTokenBucketRateLimiter(memClient, redisCacheClient), key)
+		rateLimiter = ratelimiter.NewTokenBucketRateLimiter(memClient, redisCacheClient)
 	}
+	uh := handlers.NewClusterCreateRequestHandlers(ctx, *rateLimiter, key)
+	rateLimitMiddleware := httpmiddleware.NewMiddleware(rateLimiter, httpmiddleware.JSONBodyKeyExtractor(key), algorithm.DefaultBurstSize, algorithm.DefaultTokenDropRate)
 
 	router := mux.NewRouter()
-	router.HandleFunc(fmt.Sprintf("/%s/", key), uh.HandleRequest).Methods(http.MethodPost)
+	router.Handle(fmt.Sprintf("/%s/", key), rateLimitMiddleware.Handler(http.HandlerFunc(uh.HandleRequest))).Methods(http.MethodPost)
 	router.HandleFunc(fmt.Sprintf("/%s/{%s}", key, key), uh.GetBucketStats).Methods(http.MethodGet)
 
 	log.Println("Start server on port 8080")
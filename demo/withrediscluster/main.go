@@ -16,7 +16,9 @@ import (
 	"time"
 
 	"github.com/Azure/rate-limiter/demo/handlers"
+	"github.com/Azure/rate-limiter/pkg/algorithm"
 	"github.com/Azure/rate-limiter/pkg/cache"
+	"github.com/Azure/rate-limiter/pkg/httpmiddleware"
 	"github.com/Azure/rate-limiter/ratelimiter"
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/mux"
@@ -58,10 +60,12 @@ func main() {
 
 	memClient := cache.NewMemCacheClient(10*time.Minute, 20*time.Minute)
 
-	uh := handlers.NewClusterCreateRequestHandlers(ctx, *ratelimiter.NewTokenBucketRateLimiter(memClient, cache.NewClusterClient(redisClusterClient)), key)
+	rateLimiter := ratelimiter.NewTokenBucketRateLimiter(memClient, cache.NewClusterClient(redisClusterClient))
+	uh := handlers.NewClusterCreateRequestHandlers(ctx, *rateLimiter, key)
+	rateLimitMiddleware := httpmiddleware.NewMiddleware(rateLimiter, httpmiddleware.JSONBodyKeyExtractor(key), algorithm.DefaultBurstSize, algorithm.DefaultTokenDropRate)
 
 	router := mux.NewRouter()
-	router.HandleFunc(fmt.Sprintf("/%s/", key), uh.HandleRequest).Methods(http.MethodPost)
+	router.Handle(fmt.Sprintf("/%s/", key), rateLimitMiddleware.Handler(http.HandlerFunc(uh.HandleRequest))).Methods(http.MethodPost)
 	router.HandleFunc(fmt.Sprintf("/%s/{%s}", key, key), uh.GetBucketStats).Methods(http.MethodGet)
 
 	server := http.Server{Addr: ":8080", Handler: router}
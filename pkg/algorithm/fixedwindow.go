@@ -0,0 +1,173 @@
+package algorithm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const countKey = "count"
+
+// WindowUnit is the calendar unit a fixed window is aligned to
+type WindowUnit string
+
+const (
+	WindowMinute WindowUnit = "minute"
+	WindowHour   WindowUnit = "hour"
+	WindowDay    WindowUnit = "day"
+	WindowMonth  WindowUnit = "month"
+)
+
+// FixedWindow allows at most Limit requests in every calendar window, e.g. 1000 requests per day starting at midnight in Location
+// unlike the token bucket nothing is refilled during a window, the whole quota is available again when next window starts
+type FixedWindow struct {
+	Unit     WindowUnit
+	Location *time.Location
+	Limit    int
+}
+
+// NewFixedWindowAlgorithm returns the Factory of FixedWindow aligned to unit in location
+// burst size is the quota of every window, rate is not used since window length is decided by the calendar
+func NewFixedWindowAlgorithm(unit WindowUnit, location *time.Location) Factory {
+	return func(burstSize int, rate time.Duration) (Algorithm, error) {
+		return NewFixedWindow(unit, location, burstSize)
+	}
+}
+
+func NewFixedWindow(unit WindowUnit, location *time.Location, limit int) (*FixedWindow, error) {
+	if limit <= 0 {
+		return nil, errors.New("limit must be greater than 0")
+	}
+	switch unit {
+	case WindowMinute, WindowHour, WindowDay, WindowMonth:
+	default:
+		return nil, fmt.Errorf("invalid window unit %q", unit)
+	}
+	if location == nil {
+		location = time.UTC
+	}
+	return &FixedWindow{
+		Unit:     unit,
+		Location: location,
+		Limit:    limit,
+	}, nil
+}
+
+func (w *FixedWindow) ValidateCost(cost int) error {
+	return validateCost(cost, w.Limit)
+}
+
+func (w *FixedWindow) Take(currentCache map[string]string, cost int) (Result, error) {
+	if err := w.ValidateCost(cost); err != nil {
+		return Result{}, err
+	}
+	now := time.Now()
+	windowStart, windowEnd := w.windowBounds(now)
+	count, err := w.reconstructCountFromCache(currentCache, windowStart)
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{
+		Limit:   w.Limit,
+		ResetAt: windowEnd,
+		Window:  windowEnd.Sub(windowStart),
+	}
+	if count+cost > w.Limit {
+		// count is over the limit when the limit is lowered within the window
+		result.Remaining = max(w.Limit-count, 0)
+		result.RetryAt = windowEnd
+		return result, nil
+	}
+	count += cost
+	result.Allowed = true
+	result.Remaining = w.Limit - count
+	result.CacheData = map[string]string{
+		windowStartKey: strconv.FormatInt(windowStart.Unix(), 10),
+		countKey:       strconv.Itoa(count),
+	}
+	// count is useless after the window ends
+	result.ExpireTime = windowEnd.Sub(now)
+	return result, nil
+}
+
+// Refund removes cost requests from the count of current window, requests counted in an earlier window are not refunded
+func (w *FixedWindow) Refund(currentCache map[string]string, cost int) (Result, error) {
+	if cost <= 0 {
+		return Result{}, ErrInvalidCost
+	}
+	now := time.Now()
+	windowStart, windowEnd := w.windowBounds(now)
+	count, err := w.reconstructCountFromCache(currentCache, windowStart)
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{
+		Allowed: true,
+		Limit:   w.Limit,
+		ResetAt: windowEnd,
+		Window:  windowEnd.Sub(windowStart),
+	}
+	if count == 0 {
+		result.Remaining = w.Limit
+		return result, nil
+	}
+	count = max(count-cost, 0)
+	result.Remaining = max(w.Limit-count, 0)
+	result.CacheData = map[string]string{
+		windowStartKey: strconv.FormatInt(windowStart.Unix(), 10),
+		countKey:       strconv.Itoa(count),
+	}
+	result.ExpireTime = windowEnd.Sub(now)
+	return result, nil
+}
+
+func (w *FixedWindow) Remaining(currentCache map[string]string) (int, error) {
+	windowStart, _ := w.windowBounds(time.Now())
+	count, err := w.reconstructCountFromCache(currentCache, windowStart)
+	if err != nil {
+		return 0, err
+	}
+	return max(w.Limit-count, 0), nil
+}
+
+// reconstructCountFromCache returns the saved count if it's in the window starting at windowStart, otherwise 0
+func (w *FixedWindow) reconstructCountFromCache(currentCache map[string]string, windowStart time.Time) (int, error) {
+	if len(currentCache) == 0 {
+		return 0, nil
+	}
+	savedWindowStart, err := strconv.ParseInt(currentCache[windowStartKey], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	count, err := strconv.Atoi(currentCache[countKey])
+	if err != nil {
+		return 0, err
+	}
+	if count < 0 {
+		return 0, errors.New("wrong request count")
+	}
+	if savedWindowStart != windowStart.Unix() {
+		return 0, nil
+	}
+	return count, nil
+}
+
+// windowBounds returns start and end of the window containing now
+// windows are built from wall clock in Location, so a day window is 23 or 25 hours when daylight saving time changes
+func (w *FixedWindow) windowBounds(now time.Time) (time.Time, time.Time) {
+	t := now.In(w.Location)
+	year, month, day := t.Date()
+	switch w.Unit {
+	case WindowMinute:
+		start := time.Date(year, month, day, t.Hour(), t.Minute(), 0, 0, w.Location)
+		return start, start.Add(time.Minute)
+	case WindowHour:
+		start := time.Date(year, month, day, t.Hour(), 0, 0, 0, w.Location)
+		return start, start.Add(time.Hour)
+	case WindowDay:
+		return time.Date(year, month, day, 0, 0, 0, 0, w.Location), time.Date(year, month, day+1, 0, 0, 0, 0, w.Location)
+	default:
+		return time.Date(year, month, 1, 0, 0, 0, 0, w.Location), time.Date(year, month+1, 1, 0, 0, 0, 0, w.Location)
+	}
+}
@@ -0,0 +1,108 @@
+package algorithm
+
+import (
+	"strconv"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFixedWindowBounds(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	assert.Nil(t, err)
+	now := time.Date(2024, time.March, 10, 15, 30, 45, 0, location)
+
+	for unit, expected := range map[WindowUnit][2]time.Time{
+		WindowMinute: {time.Date(2024, time.March, 10, 15, 30, 0, 0, location), time.Date(2024, time.March, 10, 15, 31, 0, 0, location)},
+		WindowHour:   {time.Date(2024, time.March, 10, 15, 0, 0, 0, location), time.Date(2024, time.March, 10, 16, 0, 0, 0, location)},
+		WindowDay:    {time.Date(2024, time.March, 10, 0, 0, 0, 0, location), time.Date(2024, time.March, 11, 0, 0, 0, 0, location)},
+		WindowMonth:  {time.Date(2024, time.March, 1, 0, 0, 0, 0, location), time.Date(2024, time.April, 1, 0, 0, 0, 0, location)},
+	} {
+		window, err := NewFixedWindow(unit, location, 10)
+		assert.Nil(t, err)
+		start, end := window.windowBounds(now)
+		assert.Equal(t, expected[0], start, unit)
+		assert.Equal(t, expected[1], end, unit)
+	}
+
+	// daylight saving time starts on 2024-03-10, the day is 23 hours
+	window, err := NewFixedWindow(WindowDay, location, 10)
+	assert.Nil(t, err)
+	start, end := window.windowBounds(now)
+	assert.Equal(t, 23*time.Hour, end.Sub(start))
+
+	// windows of the same wall clock time in different zones are different
+	window, err = NewFixedWindow(WindowDay, time.UTC, 10)
+	assert.Nil(t, err)
+	start, _ = window.windowBounds(now)
+	assert.Equal(t, time.Date(2024, time.March, 10, 0, 0, 0, 0, time.UTC), start)
+}
+
+func TestFixedWindowTake(t *testing.T) {
+	window, err := NewFixedWindow(WindowDay, time.UTC, 5)
+	assert.Nil(t, err)
+	_, windowEnd := window.windowBounds(time.Now())
+
+	result, err := window.Take(nil, 3)
+	assert.Nil(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 2, result.Remaining)
+	assert.Equal(t, windowEnd, result.ResetAt)
+	assert.Equal(t, 24*time.Hour, result.Window)
+	assert.WithinDuration(t, windowEnd, time.Now().Add(result.ExpireTime), time.Second)
+
+	currentCache := result.CacheData
+	result, err = window.Take(currentCache, 3)
+	assert.Nil(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 2, result.Remaining)
+	assert.Equal(t, windowEnd, result.RetryAt)
+
+	remaining, err := window.Remaining(currentCache)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, remaining)
+
+	// limit lowered below the count of current window
+	lowered, err := NewFixedWindow(WindowDay, time.UTC, 2)
+	assert.Nil(t, err)
+	result, err = lowered.Take(currentCache, 1)
+	assert.Nil(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+
+	// count of last window is not used
+	currentCache[windowStartKey] = strconv.FormatInt(windowEnd.AddDate(0, 0, -2).Unix(), 10)
+	remaining, err = window.Remaining(currentCache)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, remaining)
+}
+
+func TestFixedWindowWrongConfig(t *testing.T) {
+	_, err := NewFixedWindow("week", time.UTC, 5)
+	assert.NotNil(t, err)
+	_, err = NewFixedWindowAlgorithm(WindowDay, nil)(0, 0)
+	assert.NotNil(t, err)
+
+	window, err := NewFixedWindow(WindowMonth, nil, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, time.UTC, window.Location)
+	_, err = window.Take(map[string]string{windowStartKey: "wrong", countKey: "1"}, 1)
+	assert.NotNil(t, err)
+	_, err = window.Take(nil, 6)
+	assert.ErrorIs(t, err, ErrCostExceedsBurstSize)
+}
+
+func TestFixedWindowRefund(t *testing.T) {
+	w, err := NewFixedWindow(WindowDay, time.UTC, 10)
+	assert.Nil(t, err)
+	result, err := w.Take(nil, 3)
+	assert.Nil(t, err)
+
+	result, err = w.Refund(result.CacheData, 5)
+	assert.Nil(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, "0", result.CacheData[countKey])
+	assert.Equal(t, 10, result.Remaining)
+}
@@ -0,0 +1,147 @@
+package algorithm
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+const theoreticalArrivalTimeKey = "tat"
+
+// GCRA is the generic cell rate algorithm, it allows the same traffic as the token bucket
+// but only saves the theoretical arrival time (TAT) in unix nanoseconds, the time when the bucket would be full again
+// a request of cost n moves TAT forward by n * EmissionInterval, and is allowed when the new TAT is no later than now + BurstSize * EmissionInterval
+// tokens are not quantised to whole EmissionInterval steps, so retry after time is exact
+type GCRA struct {
+	EmissionInterval time.Duration // one request is allowed every emission interval on average
+	BurstSize        int
+}
+
+// NewGCRAAlgorithm is the Factory of GCRA
+func NewGCRAAlgorithm(burstSize int, rate time.Duration) (Algorithm, error) {
+	return NewGCRA(rate, burstSize)
+}
+
+func NewGCRA(emissionInterval time.Duration, burstSize int) (*GCRA, error) {
+	if burstSize <= 0 {
+		return nil, errors.New("burst size must be greater than 0")
+	}
+	if emissionInterval <= 0 {
+		return nil, errors.New("emission interval must be greater than 0")
+	}
+	return &GCRA{
+		EmissionInterval: emissionInterval,
+		BurstSize:        burstSize,
+	}, nil
+}
+
+func (g *GCRA) ValidateCost(cost int) error {
+	return validateCost(cost, g.BurstSize)
+}
+
+func (g *GCRA) Take(currentCache map[string]string, cost int) (Result, error) {
+	if err := g.ValidateCost(cost); err != nil {
+		return Result{}, err
+	}
+	now := time.Now()
+	tat, err := g.reconstructTATFromCache(currentCache, now)
+	if err != nil {
+		return Result{}, err
+	}
+	newTAT := tat.Add(time.Duration(cost) * g.EmissionInterval)
+	allowAt := newTAT.Add(-g.burstTolerance())
+	if now.Before(allowAt) {
+		return Result{
+			Limit:     g.BurstSize,
+			Window:    g.window(),
+			Remaining: g.remaining(tat, now),
+			RetryAt:   allowAt,
+			ResetAt:   tat,
+		}, nil
+	}
+	return Result{
+		Allowed:   true,
+		Limit:     g.BurstSize,
+		Window:    g.window(),
+		Remaining: g.remaining(newTAT, now),
+		ResetAt:   newTAT,
+		CacheData: map[string]string{
+			theoreticalArrivalTimeKey: strconv.FormatInt(newTAT.UnixNano(), 10),
+		},
+		// after TAT the bucket is full, same as a new key
+		ExpireTime: newTAT.Sub(now),
+	}, nil
+}
+
+// Refund moves TAT back by cost * EmissionInterval, but not before now since the bucket is full then
+func (g *GCRA) Refund(currentCache map[string]string, cost int) (Result, error) {
+	if cost <= 0 {
+		return Result{}, ErrInvalidCost
+	}
+	now := time.Now()
+	tat, err := g.reconstructTATFromCache(currentCache, now)
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{Allowed: true, Limit: g.BurstSize, Window: g.window()}
+	if !tat.After(now) {
+		result.Remaining = g.BurstSize
+		result.ResetAt = now
+		return result, nil
+	}
+	newTAT := tat.Add(-time.Duration(cost) * g.EmissionInterval)
+	if newTAT.Before(now) {
+		newTAT = now
+	}
+	result.Remaining = g.remaining(newTAT, now)
+	result.ResetAt = newTAT
+	result.CacheData = map[string]string{
+		theoreticalArrivalTimeKey: strconv.FormatInt(newTAT.UnixNano(), 10),
+	}
+	result.ExpireTime = max(newTAT.Sub(now), time.Millisecond)
+	return result, nil
+}
+
+func (g *GCRA) Remaining(currentCache map[string]string) (int, error) {
+	now := time.Now()
+	tat, err := g.reconstructTATFromCache(currentCache, now)
+	if err != nil {
+		return 0, err
+	}
+	return g.remaining(tat, now), nil
+}
+
+// reconstructTATFromCache returns the saved TAT, or now if it's already passed
+func (g *GCRA) reconstructTATFromCache(currentCache map[string]string, now time.Time) (time.Time, error) {
+	if len(currentCache) == 0 {
+		return now, nil
+	}
+	savedTAT, err := strconv.ParseInt(currentCache[theoreticalArrivalTimeKey], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if savedTAT <= 0 {
+		return time.Time{}, errors.New("wrong theoretical arrival time")
+	}
+	tat := time.Unix(0, savedTAT)
+	if tat.Before(now) {
+		return now, nil
+	}
+	return tat, nil
+}
+
+// burstTolerance is how far TAT can be ahead of now
+func (g *GCRA) burstTolerance() time.Duration {
+	return time.Duration(g.BurstSize) * g.EmissionInterval
+}
+
+// remaining is the number of requests allowed now when TAT is tat
+func (g *GCRA) remaining(tat time.Time, now time.Time) int {
+	remaining := int(now.Sub(tat.Add(-g.burstTolerance())) / g.EmissionInterval)
+	return min(max(remaining, 0), g.BurstSize)
+}
+
+// window is the time for burst size requests to be allowed again after a burst
+func (g *GCRA) window() time.Duration {
+	return time.Duration(g.BurstSize) * g.EmissionInterval
+}
@@ -0,0 +1,94 @@
+package algorithm
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGCRATake(t *testing.T) {
+	gcra, err := NewGCRA(10*time.Second, 3)
+	assert.Nil(t, err)
+
+	var currentCache map[string]string
+	for i := 0; i < 3; i++ {
+		result, err := gcra.Take(currentCache, 1)
+		assert.Nil(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 2-i, result.Remaining)
+		// full again after every taken request is emitted
+		assert.WithinDuration(t, time.Now().Add(time.Duration(i+1)*10*time.Second), result.ResetAt, 100*time.Millisecond)
+		assert.Len(t, result.CacheData, 1)
+		currentCache = result.CacheData
+	}
+
+	// next request is allowed exactly one emission interval after the first one
+	result, err := gcra.Take(currentCache, 1)
+	assert.Nil(t, err)
+	assert.False(t, result.Allowed)
+	assert.Nil(t, result.CacheData)
+	assert.WithinDuration(t, time.Now().Add(10*time.Second), result.RetryAt, 100*time.Millisecond)
+}
+
+func TestGCRATakeWithCost(t *testing.T) {
+	gcra, err := NewGCRA(time.Second, 10)
+	assert.Nil(t, err)
+	// 2.5s of requests emitted ahead of now, 7 requests are allowed now
+	tat := time.Now().Add(2500 * time.Millisecond).Round(0)
+	currentCache := map[string]string{theoreticalArrivalTimeKey: strconv.FormatInt(tat.UnixNano(), 10)}
+
+	remaining, err := gcra.Remaining(currentCache)
+	assert.Nil(t, err)
+	assert.Equal(t, 7, remaining)
+
+	// 8 requests are allowed 500ms later, not quantised to whole seconds
+	result, err := gcra.Take(currentCache, 8)
+	assert.Nil(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, tat.Add(-2*time.Second), result.RetryAt)
+
+	result, err = gcra.Take(currentCache, 7)
+	assert.Nil(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, strconv.FormatInt(tat.Add(7*time.Second).UnixNano(), 10), result.CacheData[theoreticalArrivalTimeKey])
+}
+
+func TestGCRAWrongData(t *testing.T) {
+	gcra, err := NewGCRA(time.Second, 10)
+	assert.Nil(t, err)
+
+	_, err = gcra.Take(map[string]string{theoreticalArrivalTimeKey: "wrong"}, 1)
+	assert.NotNil(t, err)
+	_, err = gcra.Take(map[string]string{theoreticalArrivalTimeKey: "-1"}, 1)
+	assert.NotNil(t, err)
+	_, err = gcra.Take(nil, 11)
+	assert.ErrorIs(t, err, ErrCostExceedsBurstSize)
+	_, err = NewGCRAAlgorithm(10, 0)
+	assert.NotNil(t, err)
+
+	// TAT in the past is a full bucket
+	remaining, err := gcra.Remaining(map[string]string{theoreticalArrivalTimeKey: strconv.FormatInt(time.Now().Add(-time.Hour).UnixNano(), 10)})
+	assert.Nil(t, err)
+	assert.Equal(t, 10, remaining)
+}
+
+func TestGCRARefund(t *testing.T) {
+	gcra, err := NewGCRA(time.Second, 10)
+	assert.Nil(t, err)
+	result, err := gcra.Take(nil, 5)
+	assert.Nil(t, err)
+
+	result, err = gcra.Refund(result.CacheData, 2)
+	assert.Nil(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 7, result.Remaining)
+	assert.WithinDuration(t, time.Now().Add(3*time.Second), result.ResetAt, 100*time.Millisecond)
+
+	// TAT doesn't move before now
+	result, err = gcra.Refund(result.CacheData, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, result.Remaining)
+}
@@ -0,0 +1,69 @@
+package algorithm
+
+import (
+	"fmt"
+	"time"
+)
+
+// Algorithm is a rate limiting algorithm whose whole state is kept in a cache record
+// Take and Refund only compute the new state, the caller reads the record and saves it back,
+// only Bucket is also run by a lua script on the server, see cache.TokenBucketCacheClient
+type Algorithm interface {
+	// Take takes cost units from the state in currentCache, currentCache is empty for a new key
+	// when the result is not allowed nothing is taken and the cache should not be updated
+	Take(currentCache map[string]string, cost int) (Result, error)
+	// Remaining returns the number of units can be taken now
+	Remaining(currentCache map[string]string) (int, error)
+	// ValidateCost checks if cost units can ever be taken
+	ValidateCost(cost int) error
+}
+
+// Refunder is implemented by algorithms which can give back units taken before, e.g. when the request turns out not to count
+type Refunder interface {
+	// Refund gives cost units back to the state in currentCache, no more units than the limit are available after refunding
+	// result is always allowed, CacheData is the new state to save, nil when there is nothing to give back
+	Refund(currentCache map[string]string, cost int) (Result, error)
+}
+
+// Factory builds an algorithm from the burst size and rate passed to the rate limiter
+// burst size and rate give the same average rate for every algorithm, one unit per rate
+type Factory func(burstSize int, rate time.Duration) (Algorithm, error)
+
+// names of algorithms in config
+const (
+	AlgorithmTokenBucket   = "token_bucket"
+	AlgorithmSlidingWindow = "sliding_window"
+	AlgorithmGCRA          = "gcra"
+	AlgorithmFixedWindow   = "fixed_window"
+)
+
+// FactoryByName returns the Factory of algorithm name
+// fixed window is not included since it also needs a window unit, use NewFixedWindowAlgorithm
+func FactoryByName(name string) (Factory, error) {
+	switch name {
+	case AlgorithmTokenBucket:
+		return NewTokenBucketAlgorithm, nil
+	case AlgorithmSlidingWindow:
+		return NewSlidingWindowAlgorithm, nil
+	case AlgorithmGCRA:
+		return NewGCRAAlgorithm, nil
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q", name)
+	}
+}
+
+type Result struct {
+	Allowed bool
+	Limit   int
+	// Remaining is the number of units left after taking, or units available now when not allowed
+	Remaining int
+	// RetryAt is the time when cost units will be available, only set when not allowed
+	RetryAt time.Time
+	// ResetAt is the time when all units will be available again
+	ResetAt time.Time
+	// Window is the time window Limit applies to, e.g. for an empty bucket to be full, it's the quota window of RateLimit-Policy
+	Window time.Duration
+	// CacheData is the new state to save for ExpireTime, only set when allowed
+	CacheData  map[string]string
+	ExpireTime time.Duration
+}
@@ -0,0 +1,200 @@
+package algorithm
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	windowStartKey   = "windowStart"
+	currentCountKey  = "currentCount"
+	previousCountKey = "previousCount"
+)
+
+// SlidingWindow allows at most Limit requests in any rolling Window
+// it keeps counts of the current and previous fixed windows, and weights the previous count by how much of it overlaps the rolling window
+// let's say limit is 10 per minute, previous window has 8 requests, current window has 3 requests and 15s passed in current window
+// the rolling window covers 45s of previous window, estimated count is 8 * 45 / 60 + 3 = 9, 1 more request is allowed
+type SlidingWindow struct {
+	Window time.Duration
+	Limit  int
+}
+
+type windowState struct {
+	// windowStart is the start of current window, windows are aligned to unix epoch
+	windowStart   time.Time
+	currentCount  int
+	previousCount int
+}
+
+// NewSlidingWindowAlgorithm is the Factory of SlidingWindow
+// burst size requests are allowed in a window of burstSize * rate, so the average rate is the same as the token bucket
+// e.g. 100 requests per rolling minute is burst size 100 and rate 600ms
+func NewSlidingWindowAlgorithm(burstSize int, rate time.Duration) (Algorithm, error) {
+	return NewSlidingWindow(time.Duration(burstSize)*rate, burstSize)
+}
+
+func NewSlidingWindow(window time.Duration, limit int) (*SlidingWindow, error) {
+	if limit <= 0 {
+		return nil, errors.New("limit must be greater than 0")
+	}
+	if window < time.Millisecond {
+		return nil, errors.New("window must be at least 1ms")
+	}
+	return &SlidingWindow{
+		Window: window,
+		Limit:  limit,
+	}, nil
+}
+
+func (w *SlidingWindow) ValidateCost(cost int) error {
+	return validateCost(cost, w.Limit)
+}
+
+func (w *SlidingWindow) Take(currentCache map[string]string, cost int) (Result, error) {
+	if err := w.ValidateCost(cost); err != nil {
+		return Result{}, err
+	}
+	now := time.Now()
+	ws, err := w.reconstructWindowStateFromCache(currentCache, now)
+	if err != nil {
+		return Result{}, err
+	}
+	count := ws.estimatedCount(w.Window, now)
+	result := Result{Limit: w.Limit, Window: w.Window}
+	if count+float64(cost) > float64(w.Limit) {
+		result.RetryAt = w.retryAt(ws, cost)
+		result.Remaining = remainingCount(w.Limit, count)
+		result.ResetAt = w.resetAt(ws, now)
+		return result, nil
+	}
+	ws.currentCount += cost
+	result.Allowed = true
+	result.Remaining = remainingCount(w.Limit, count+float64(cost))
+	result.ResetAt = w.resetAt(ws, now)
+	result.CacheData = map[string]string{
+		windowStartKey:   strconv.FormatInt(ws.windowStart.UnixMilli(), 10),
+		currentCountKey:  strconv.Itoa(ws.currentCount),
+		previousCountKey: strconv.Itoa(ws.previousCount),
+	}
+	// current count is still used as previous count in next window
+	result.ExpireTime = ws.windowStart.Add(2 * w.Window).Sub(now)
+	return result, nil
+}
+
+// Refund removes cost requests from the current window, the rest from the previous window when the requests were counted there
+func (w *SlidingWindow) Refund(currentCache map[string]string, cost int) (Result, error) {
+	if cost <= 0 {
+		return Result{}, ErrInvalidCost
+	}
+	now := time.Now()
+	ws, err := w.reconstructWindowStateFromCache(currentCache, now)
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{Allowed: true, Limit: w.Limit, Window: w.Window}
+	if ws.currentCount == 0 && ws.previousCount == 0 {
+		result.Remaining = w.Limit
+		result.ResetAt = now
+		return result, nil
+	}
+	fromCurrent := min(cost, ws.currentCount)
+	ws.currentCount -= fromCurrent
+	ws.previousCount = max(ws.previousCount-(cost-fromCurrent), 0)
+	result.Remaining = remainingCount(w.Limit, ws.estimatedCount(w.Window, now))
+	result.ResetAt = w.resetAt(ws, now)
+	result.CacheData = map[string]string{
+		windowStartKey:   strconv.FormatInt(ws.windowStart.UnixMilli(), 10),
+		currentCountKey:  strconv.Itoa(ws.currentCount),
+		previousCountKey: strconv.Itoa(ws.previousCount),
+	}
+	result.ExpireTime = ws.windowStart.Add(2 * w.Window).Sub(now)
+	return result, nil
+}
+
+func (w *SlidingWindow) Remaining(currentCache map[string]string) (int, error) {
+	now := time.Now()
+	ws, err := w.reconstructWindowStateFromCache(currentCache, now)
+	if err != nil {
+		return 0, err
+	}
+	return remainingCount(w.Limit, ws.estimatedCount(w.Window, now)), nil
+}
+
+// reconstructWindowStateFromCache moves the saved windows to the window of now
+// saved current window becomes previous window when now is in the next window, both are dropped when now is even later
+func (w *SlidingWindow) reconstructWindowStateFromCache(currentCache map[string]string, now time.Time) (*windowState, error) {
+	windowStart := time.UnixMilli(now.UnixMilli() / w.Window.Milliseconds() * w.Window.Milliseconds())
+	if len(currentCache) == 0 {
+		return &windowState{windowStart: windowStart}, nil
+	}
+	savedWindowStart, err := strconv.ParseInt(currentCache[windowStartKey], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	currentCount, err := strconv.Atoi(currentCache[currentCountKey])
+	if err != nil {
+		return nil, err
+	}
+	previousCount, err := strconv.Atoi(currentCache[previousCountKey])
+	if err != nil {
+		return nil, err
+	}
+	if currentCount < 0 || previousCount < 0 {
+		return nil, errors.New("wrong request count")
+	}
+	switch windowStart.UnixMilli() - savedWindowStart {
+	case 0:
+		return &windowState{windowStart: windowStart, currentCount: currentCount, previousCount: previousCount}, nil
+	case w.Window.Milliseconds():
+		return &windowState{windowStart: windowStart, previousCount: currentCount}, nil
+	default:
+		return &windowState{windowStart: windowStart}, nil
+	}
+}
+
+// estimatedCount is the number of requests in the rolling window ending at now
+func (ws *windowState) estimatedCount(window time.Duration, now time.Time) float64 {
+	overlap := 1 - float64(now.Sub(ws.windowStart))/float64(window)
+	return float64(ws.previousCount)*overlap + float64(ws.currentCount)
+}
+
+// retryAt returns the first time estimated count + cost will not exceed the limit
+func (w *SlidingWindow) retryAt(ws *windowState, cost int) time.Time {
+	// in current window, previous count goes down as time goes on
+	if ws.previousCount > 0 {
+		overlapNeeded := float64(w.Limit-ws.currentCount-cost) / float64(ws.previousCount)
+		if overlapNeeded >= 0 {
+			return ws.windowStart.Add(ceilDuration(float64(w.Window) * (1 - overlapNeeded)))
+		}
+	}
+	// in next window, current count becomes previous count
+	nextWindowStart := ws.windowStart.Add(w.Window)
+	if ws.currentCount == 0 {
+		return nextWindowStart
+	}
+	overlapNeeded := math.Min(float64(w.Limit-cost)/float64(ws.currentCount), 1)
+	return nextWindowStart.Add(ceilDuration(float64(w.Window) * (1 - overlapNeeded)))
+}
+
+// resetAt returns the time when no request is counted in the rolling window
+func (w *SlidingWindow) resetAt(ws *windowState, now time.Time) time.Time {
+	switch {
+	case ws.currentCount > 0:
+		return ws.windowStart.Add(2 * w.Window)
+	case ws.previousCount > 0:
+		return ws.windowStart.Add(w.Window)
+	default:
+		return now
+	}
+}
+
+func remainingCount(limit int, count float64) int {
+	return max(int(math.Floor(float64(limit)-count)), 0)
+}
+
+func ceilDuration(d float64) time.Duration {
+	return time.Duration(math.Ceil(d))
+}
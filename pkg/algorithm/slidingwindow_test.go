@@ -0,0 +1,99 @@
+package algorithm
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlidingWindowTake(t *testing.T) {
+	window, err := NewSlidingWindow(time.Hour, 3)
+	assert.Nil(t, err)
+
+	var currentCache map[string]string
+	for i := 0; i < 3; i++ {
+		result, err := window.Take(currentCache, 1)
+		assert.Nil(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 2-i, result.Remaining)
+		assert.Equal(t, strconv.Itoa(i+1), result.CacheData[currentCountKey])
+		currentCache = result.CacheData
+	}
+	result, err := window.Take(currentCache, 1)
+	assert.Nil(t, err)
+	assert.False(t, result.Allowed)
+	assert.Nil(t, result.CacheData)
+	assert.True(t, result.RetryAt.After(time.Now()))
+
+	remaining, err := window.Remaining(currentCache)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, remaining)
+}
+
+func TestSlidingWindowPreviousWindow(t *testing.T) {
+	window, err := NewSlidingWindow(time.Minute, 10)
+	assert.Nil(t, err)
+	windowStart := time.Now().Truncate(time.Minute)
+	// saved in previous window
+	currentCache := map[string]string{
+		windowStartKey:   strconv.FormatInt(windowStart.Add(-time.Minute).UnixMilli(), 10),
+		currentCountKey:  "8",
+		previousCountKey: "5",
+	}
+
+	ws, err := window.reconstructWindowStateFromCache(currentCache, windowStart.Add(15*time.Second))
+	assert.Nil(t, err)
+	assert.Equal(t, 8, ws.previousCount)
+	assert.Equal(t, 0, ws.currentCount)
+
+	// 45s of previous window is in the rolling window
+	ws.currentCount = 3
+	assert.Equal(t, 9.0, ws.estimatedCount(time.Minute, windowStart.Add(15*time.Second)))
+	// 2 requests are allowed when 8 * (1 - t/60) + 3 + 2 <= 10, at 22.5s
+	assert.WithinDuration(t, windowStart.Add(22500*time.Millisecond), window.retryAt(ws, 2), time.Millisecond)
+	// 8 requests need previous window to end and current count 3 to weight no more than 2
+	assert.WithinDuration(t, windowStart.Add(time.Minute+20*time.Second), window.retryAt(ws, 8), time.Millisecond)
+	assert.Equal(t, windowStart.Add(2*time.Minute), window.resetAt(ws, windowStart))
+
+	// saved long ago
+	ws, err = window.reconstructWindowStateFromCache(currentCache, windowStart.Add(time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, ws.previousCount)
+	assert.Equal(t, 0, ws.currentCount)
+}
+
+func TestSlidingWindowWrongData(t *testing.T) {
+	window, err := NewSlidingWindow(time.Minute, 10)
+	assert.Nil(t, err)
+
+	_, err = window.Take(map[string]string{windowStartKey: "wrong", currentCountKey: "1", previousCountKey: "1"}, 1)
+	assert.NotNil(t, err)
+	_, err = window.Take(map[string]string{windowStartKey: "0", currentCountKey: "-1", previousCountKey: "1"}, 1)
+	assert.NotNil(t, err)
+	_, err = window.Take(nil, 11)
+	assert.ErrorIs(t, err, ErrCostExceedsBurstSize)
+
+	_, err = NewSlidingWindowAlgorithm(0, time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestSlidingWindowRefund(t *testing.T) {
+	w, err := NewSlidingWindow(time.Hour, 10)
+	assert.Nil(t, err)
+	result, err := w.Take(nil, 4)
+	assert.Nil(t, err)
+
+	result, err = w.Refund(result.CacheData, 3)
+	assert.Nil(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, "1", result.CacheData[currentCountKey])
+	assert.Equal(t, 9, result.Remaining)
+
+	// nothing to refund for a new key
+	result, err = w.Refund(nil, 1)
+	assert.Nil(t, err)
+	assert.Nil(t, result.CacheData)
+	assert.Equal(t, 10, result.Remaining)
+}
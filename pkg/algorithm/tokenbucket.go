@@ -20,14 +20,30 @@ type tokenState struct {
 const (
 	tokenNumberKey           = "tokens"
 	tokenLastIncreaseTimeKey = "tokenLastIncreaseTime"
-	DefaultTokenDropRate     = time.Minute
-	DefaultBurstSize         = 10
+	// tokenTimeLayout is RFC3339 with microseconds, reserved tokens of sub second rates are due within a second
+	// time.RFC3339 parses it, and values saved with milliseconds or without fraction before
+	tokenTimeLayout      = "2006-01-02T15:04:05.000000Z07:00"
+	DefaultTokenDropRate = time.Minute
+	DefaultBurstSize     = 10
 )
 
+var (
+	ErrInvalidCost          = errors.New("cost must be greater than 0")
+	ErrCostExceedsBurstSize = errors.New("cost exceeds burst size")
+)
+
+// NewTokenBucketAlgorithm is the Factory of Bucket
+func NewTokenBucketAlgorithm(burstSize int, rate time.Duration) (Algorithm, error) {
+	return NewBucket(rate, burstSize)
+}
+
 func NewBucket(tokenDropRate time.Duration, burstSize int) (*Bucket, error) {
 	if burstSize <= 0 {
 		return nil, errors.New("burst size must be greater than 0")
 	}
+	if tokenDropRate <= 0 {
+		return nil, errors.New("token drop rate must be greater than 0")
+	}
 	bucket := &Bucket{
 		TokenDropRate: tokenDropRate,
 		BurstSize:     burstSize,
@@ -42,6 +58,28 @@ func NewBucket(tokenDropRate time.Duration, burstSize int) (*Bucket, error) {
 // then we don't need to keep this bucket in the cache
 // when user request with this id come again after expiration, we will just start a new bucket with 10 tokens
 func (b *Bucket) TakeToken(currentCache map[string]string) (int, time.Time, time.Duration, error) {
+	return b.TakeTokens(currentCache, 1)
+}
+
+// TakeTokens takes cost tokens at once, return values are the same as TakeToken
+// when token number returned < 0, there are not enough tokens and nothing should be taken,
+// use RetryAt to get the time when cost tokens will be available
+func (b *Bucket) TakeTokens(currentCache map[string]string, cost int) (int, time.Time, time.Duration, error) {
+	return b.takeTokens(currentCache, cost, 0)
+}
+
+// ReserveTokens takes cost tokens like TakeTokens, but when they are not enough and the missing tokens will be added within maxWait,
+// they are borrowed from the future: token number becomes 0 and last increase time moves to the time the last missing token is added,
+// which is the time the caller should wait for, requests reserving later queue after it
+// token number returned < 0 means the tokens can't be reserved within maxWait, nothing should be taken
+func (b *Bucket) ReserveTokens(currentCache map[string]string, cost int, maxWait time.Duration) (int, time.Time, time.Duration, error) {
+	return b.takeTokens(currentCache, cost, maxWait)
+}
+
+func (b *Bucket) takeTokens(currentCache map[string]string, cost int, maxWait time.Duration) (int, time.Time, time.Duration, error) {
+	if err := b.ValidateCost(cost); err != nil {
+		return 0, time.Time{}, 0, err
+	}
 	var ts *tokenState
 	var err error
 	// if fail to construct, then start a new bucket, but err will return
@@ -49,7 +87,13 @@ func (b *Bucket) TakeToken(currentCache map[string]string) (int, time.Time, time
 		ts = &tokenState{tokenNumbers: b.BurstSize, lastIncreaseTime: time.Now()}
 	}
 
-	ts.tokenNumbers--
+	ts.tokenNumbers -= cost
+	if ts.tokenNumbers < 0 && maxWait > 0 {
+		if readyAt := b.RetryAt(ts.tokenNumbers, ts.lastIncreaseTime); time.Until(readyAt) <= maxWait {
+			ts.tokenNumbers = 0
+			ts.lastIncreaseTime = readyAt
+		}
+	}
 
 	var tokesLeftForBucketToFull int
 	if ts.tokenNumbers < 0 {
@@ -62,6 +106,109 @@ func (b *Bucket) TakeToken(currentCache map[string]string) (int, time.Time, time
 	return ts.tokenNumbers, ts.lastIncreaseTime, time.Until(timeForCurrentbucketToFull), err
 }
 
+// Take implements Algorithm with TakeTokens
+func (b *Bucket) Take(currentCache map[string]string, cost int) (Result, error) {
+	tokenNumbers, lastIncreaseTime, expireTime, err := b.TakeTokens(currentCache, cost)
+	if err != nil {
+		return Result{}, err
+	}
+	return b.NewResult(tokenNumbers, lastIncreaseTime, expireTime, cost), nil
+}
+
+// NewResult converts values returned by TakeTokens to Result
+func (b *Bucket) NewResult(tokenNumbers int, lastIncreaseTime time.Time, expireTime time.Duration, cost int) Result {
+	result := Result{
+		Allowed: true,
+		Limit:   b.BurstSize,
+		Window:  time.Duration(b.BurstSize) * b.TokenDropRate,
+	}
+	if tokenNumbers < 0 {
+		// when tokenNumber < 0 means too many requests, tokens are not taken
+		result.Allowed = false
+		result.RetryAt = b.RetryAt(tokenNumbers, lastIncreaseTime)
+		tokenNumbers += cost
+	} else {
+		// saved time is rounded up to whole microseconds, so the bucket never refills faster than the rate
+		result.CacheData = map[string]string{
+			tokenNumberKey:           strconv.Itoa(tokenNumbers),
+			tokenLastIncreaseTimeKey: lastIncreaseTime.Add(time.Microsecond - time.Nanosecond).Format(tokenTimeLayout),
+		}
+		result.ExpireTime = expireTime
+	}
+	result.Remaining = tokenNumbers
+	result.ResetAt = b.FullAt(tokenNumbers, lastIncreaseTime)
+	return result
+}
+
+// RefundTokens gives cost tokens back, token number never exceeds burst size
+// tokens reserved ahead are given back first, the time they are available moves back so the next reservation is due earlier
+// return token number after refunding, last increase time and expire time like TakeTokens
+func (b *Bucket) RefundTokens(currentCache map[string]string, cost int) (int, time.Time, time.Duration, error) {
+	if cost <= 0 {
+		return 0, time.Time{}, 0, ErrInvalidCost
+	}
+	ts, err := b.reconstructTokenStateFromCache(currentCache)
+	if err != nil {
+		return 0, time.Time{}, 0, err
+	}
+	if reserved := time.Until(ts.lastIncreaseTime); reserved > 0 {
+		returned := min(cost, int((reserved+b.TokenDropRate-1)/b.TokenDropRate))
+		ts.lastIncreaseTime = ts.lastIncreaseTime.Add(-time.Duration(returned) * b.TokenDropRate)
+		cost -= returned
+	}
+	ts.tokenNumbers = min(ts.tokenNumbers+cost, b.BurstSize)
+	timeForCurrentbucketToFull := ts.lastIncreaseTime.Add(time.Duration(b.BurstSize-ts.tokenNumbers) * b.TokenDropRate)
+	// a full bucket is already expired, a non-positive expire time would keep it in memcache forever
+	return ts.tokenNumbers, ts.lastIncreaseTime, max(time.Until(timeForCurrentbucketToFull), time.Millisecond), nil
+}
+
+// Refund implements Refunder with RefundTokens
+func (b *Bucket) Refund(currentCache map[string]string, cost int) (Result, error) {
+	tokenNumbers, lastIncreaseTime, expireTime, err := b.RefundTokens(currentCache, cost)
+	if err != nil {
+		return Result{}, err
+	}
+	return b.NewResult(tokenNumbers, lastIncreaseTime, expireTime, 0), nil
+}
+
+// Remaining implements Algorithm with GetTokenNumber
+func (b *Bucket) Remaining(currentCache map[string]string) (int, error) {
+	return b.GetTokenNumber(currentCache)
+}
+
+// ValidateCost checks if cost tokens can ever be taken from the bucket
+func (b *Bucket) ValidateCost(cost int) error {
+	return validateCost(cost, b.BurstSize)
+}
+
+// validateCost checks cost is positive and no more than limit
+func validateCost(cost int, limit int) error {
+	if cost <= 0 {
+		return ErrInvalidCost
+	}
+	if cost > limit {
+		return ErrCostExceedsBurstSize
+	}
+	return nil
+}
+
+// RetryAt returns the time when the tokens taken by a rejected TakeTokens will be available
+// tokenNumbers and lastIncreaseTime are the values returned by TakeTokens, -tokenNumbers is the number of missing tokens
+func (b *Bucket) RetryAt(tokenNumbers int, lastIncreaseTime time.Time) time.Time {
+	if tokenNumbers >= 0 {
+		return time.Now()
+	}
+	return lastIncreaseTime.Add(time.Duration(-tokenNumbers) * b.TokenDropRate)
+}
+
+// FullAt returns the time when the bucket with tokenNumbers tokens will reach burst size
+func (b *Bucket) FullAt(tokenNumbers int, lastIncreaseTime time.Time) time.Time {
+	if tokenNumbers >= b.BurstSize {
+		return time.Now()
+	}
+	return lastIncreaseTime.Add(time.Duration(b.BurstSize-tokenNumbers) * b.TokenDropRate)
+}
+
 func (b *Bucket) GetTokenNumber(currentCache map[string]string) (int, error) {
 	var tokenState *tokenState
 	var err error
@@ -97,6 +244,10 @@ func (b *Bucket) reconstructTokenStateFromCache(currentCache map[string]string)
 	}
 	currentTime := time.Now()
 	elapsedTime := currentTime.Sub(tokenLastIncreaseTime)
+	if elapsedTime < 0 {
+		// last increase time is in the future when tokens are reserved ahead, no token is added until then
+		elapsedTime = 0
+	}
 	// calculate tokens
 	shouldIncreaseTokens := int(elapsedTime / b.TokenDropRate)
 	tokensNow := shouldIncreaseTokens + lastSavedTokens
@@ -48,6 +48,15 @@ func TestReconstructTokenStateFromCacheOverBurstSize(t *testing.T) {
 	assert.True(t, bucketStats.lastIncreaseTime.After(time.Now().Add(-time.Second*1)))
 }
 
+func TestNewBucketWrongConfig(t *testing.T) {
+	_, err := NewBucket(0, 10)
+	assert.NotNil(t, err)
+	_, err = NewBucket(-time.Second, 10)
+	assert.NotNil(t, err)
+	_, err = NewBucket(time.Second, 0)
+	assert.NotNil(t, err)
+}
+
 func TestReconstructTokenStateFromCacheWithWrongData(t *testing.T) {
 	bucket, err := NewBucket(30*time.Second, 10)
 	assert.Nil(t, err)
@@ -100,3 +109,128 @@ func TestTakeTokenWrongData(t *testing.T) {
 	// about 30s before expire
 	assert.Equal(t, 1, int(30*time.Second/expireTime))
 }
+
+func TestTakeTokens(t *testing.T) {
+	bucket, err := NewBucket(30*time.Second, 10)
+	assert.Nil(t, err)
+	currentCache := map[string]string{
+		tokenNumberKey:           "2",
+		tokenLastIncreaseTimeKey: time.Now().Add(-time.Second * 10).Format(time.RFC3339),
+	}
+
+	tokenNumbers, _, _, err := bucket.TakeTokens(currentCache, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, tokenNumbers)
+
+	// 3 tokens missing, the last one is added 90s after last increase time
+	tokenNumbers, lastIncreaseTime, _, err := bucket.TakeTokens(currentCache, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, -3, tokenNumbers)
+	assert.Equal(t, lastIncreaseTime.Add(90*time.Second), bucket.RetryAt(tokenNumbers, lastIncreaseTime))
+}
+
+func TestTakeTokensWrongCost(t *testing.T) {
+	bucket, err := NewBucket(30*time.Second, 10)
+	assert.Nil(t, err)
+
+	_, _, _, err = bucket.TakeTokens(nil, 0)
+	assert.ErrorIs(t, err, ErrInvalidCost)
+	_, _, _, err = bucket.TakeTokens(nil, 11)
+	assert.ErrorIs(t, err, ErrCostExceedsBurstSize)
+	assert.Nil(t, bucket.ValidateCost(10))
+}
+
+func TestReserveTokens(t *testing.T) {
+	bucket, err := NewBucket(30*time.Second, 10)
+	assert.Nil(t, err)
+	lastIncreaseTime := time.Now().Add(-time.Second * 10).Truncate(time.Second)
+	currentCache := map[string]string{
+		tokenNumberKey:           "1",
+		tokenLastIncreaseTimeKey: lastIncreaseTime.Format(time.RFC3339),
+	}
+
+	// 2 tokens missing, the last one is added 60s after last increase time, too late
+	tokenNumbers, _, _, err := bucket.ReserveTokens(currentCache, 3, 30*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, -2, tokenNumbers)
+
+	tokenNumbers, readyAt, expireTime, err := bucket.ReserveTokens(currentCache, 3, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, tokenNumbers)
+	assert.True(t, lastIncreaseTime.Add(time.Minute).Equal(readyAt))
+	assert.InDelta(t, float64(time.Until(readyAt.Add(300*time.Second))), float64(expireTime), float64(time.Second))
+
+	// next reservation queues after it
+	currentCache = map[string]string{
+		tokenNumberKey:           "0",
+		tokenLastIncreaseTimeKey: readyAt.Format(time.RFC3339),
+	}
+	tokenNumbers, nextReadyAt, _, err := bucket.ReserveTokens(currentCache, 1, 2*time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, tokenNumbers)
+	assert.True(t, readyAt.Add(30*time.Second).Equal(nextReadyAt))
+}
+
+func TestReserveTokensSubSecondRate(t *testing.T) {
+	bucket, err := NewBucket(200*time.Millisecond, 1)
+	assert.Nil(t, err)
+
+	// reservations are saved with milliseconds, so every waiter gets its own slot
+	var currentCache map[string]string
+	var lastReadyAt time.Time
+	for i := 0; i < 3; i++ {
+		tokenNumbers, readyAt, expireTime, err := bucket.ReserveTokens(currentCache, 1, time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, tokenNumbers)
+		if i > 0 {
+			assert.InDelta(t, float64(200*time.Millisecond), float64(readyAt.Sub(lastReadyAt)), float64(time.Millisecond))
+		}
+		lastReadyAt = readyAt
+		currentCache = bucket.NewResult(tokenNumbers, readyAt, expireTime, 1).CacheData
+	}
+}
+
+func TestRefundTokens(t *testing.T) {
+	bucket, err := NewBucket(30*time.Second, 10)
+	assert.Nil(t, err)
+	currentCache := map[string]string{
+		tokenNumberKey:           "7",
+		tokenLastIncreaseTimeKey: time.Now().Add(-time.Second * 10).Format(time.RFC3339),
+	}
+
+	result, err := bucket.Refund(currentCache, 2)
+	assert.Nil(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 9, result.Remaining)
+	assert.Equal(t, "9", result.CacheData[tokenNumberKey])
+
+	// capped at burst size
+	tokenNumbers, _, _, err := bucket.RefundTokens(currentCache, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, tokenNumbers)
+
+	_, err = bucket.Refund(currentCache, 0)
+	assert.ErrorIs(t, err, ErrInvalidCost)
+}
+
+func TestRefundReservedTokens(t *testing.T) {
+	bucket, err := NewBucket(30*time.Second, 10)
+	assert.Nil(t, err)
+	// 2 tokens are reserved ahead, they are available in 1m
+	lastIncreaseTime := time.Now().Add(time.Minute)
+	currentCache := map[string]string{
+		tokenNumberKey:           "0",
+		tokenLastIncreaseTimeKey: lastIncreaseTime.Format(time.RFC3339Nano),
+	}
+
+	// reserved tokens are given back before tokens are added
+	tokenNumbers, newLastIncreaseTime, _, err := bucket.RefundTokens(currentCache, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, tokenNumbers)
+	assert.WithinDuration(t, lastIncreaseTime.Add(-30*time.Second), newLastIncreaseTime, time.Microsecond)
+
+	tokenNumbers, newLastIncreaseTime, _, err = bucket.RefundTokens(currentCache, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, tokenNumbers)
+	assert.WithinDuration(t, lastIncreaseTime.Add(-time.Minute), newLastIncreaseTime, time.Microsecond)
+}
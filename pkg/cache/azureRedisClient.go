@@ -52,7 +52,9 @@ func (d *azureCacheTokenFetcher) getToken(ctx context.Context) (string, error) {
 	return d.accessToken.Token, nil
 }
 
-func (d *azureCacheTokenFetcher) refreshToken(ctx context.Context) error {
+func (d *azureCacheTokenFetcher) refreshToken(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, "azureCacheTokenFetcher.refreshToken", "azure_redis")
+	defer func() { endSpan(span, err) }()
 	token, err := d.cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{azureRedisScope}})
 	if err != nil {
 		return fmt.Errorf("get token err: %w", err)
@@ -96,30 +98,42 @@ func buildRedisClient(ctx context.Context, redisHost, msiObjectID, redisPassword
 		WriteTimeout: redisDefaultWriteTimeout,
 	}
 	client := redis.NewClient(op)
-	err := client.Ping(ctx).Err()
+	err := traceCommand(ctx, "PING", func(ctx context.Context) error { return client.Ping(ctx).Err() })
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("failed to connect with redis instance at %s - %v", redisHost, err))
 	}
 	return client, nil
 }
 
-func (c *AzureRedisClient) UpdateCache(ctx context.Context, key string, cacheData map[string]string, expireTime time.Duration) error {
-	if c.tokenFetcher.tokenExpired() {
-		if err := c.tokenFetcher.refreshToken(ctx); err != nil {
-			return err
-		}
-		// update password when token expired
-		newClient, err := buildRedisClient(ctx, c.redisClient.Options().Addr, c.redisClient.Options().Username, c.tokenFetcher.accessToken.Token)
-		if err != nil {
-			return err
-		}
-		c.redisClient = newClient
+// refreshClient rebuilds redis client with a new password when token expired
+func (c *AzureRedisClient) refreshClient(ctx context.Context) (err error) {
+	if !c.tokenFetcher.tokenExpired() {
+		return nil
+	}
+	ctx, span := startSpan(ctx, "AzureRedisClient.refreshClient", "azure_redis")
+	defer func() { endSpan(span, err) }()
+	if err := c.tokenFetcher.refreshToken(ctx); err != nil {
+		return err
+	}
+	newClient, err := buildRedisClient(ctx, c.redisClient.Options().Addr, c.redisClient.Options().Username, c.tokenFetcher.accessToken.Token)
+	if err != nil {
+		return err
+	}
+	c.redisClient = newClient
+	return nil
+}
+
+func (c *AzureRedisClient) UpdateCache(ctx context.Context, key string, cacheData map[string]string, expireTime time.Duration) (err error) {
+	ctx, span := startSpan(ctx, "AzureRedisClient.UpdateCache", "azure_redis")
+	defer func() { endSpan(span, err) }()
+	if err := c.refreshClient(ctx); err != nil {
+		return err
 	}
-	err := c.redisClient.Ping(ctx).Err()
+	err = traceCommand(ctx, "PING", func(ctx context.Context) error { return c.redisClient.Ping(ctx).Err() })
 	if err != nil {
-		return fmt.Errorf("failed to connect with redis instance: %s", err.Error())
+		return fmt.Errorf("failed to connect with redis instance: %w", err)
 	}
-	_, err = c.redisClient.HSet(ctx, key, cacheData).Result()
+	err = traceCommand(ctx, "HSET", func(ctx context.Context) error { return c.redisClient.HSet(ctx, key, cacheData).Err() })
 	if err != nil {
 		return err
 	}
@@ -127,10 +141,70 @@ func (c *AzureRedisClient) UpdateCache(ctx context.Context, key string, cacheDat
 	return nil
 }
 
-func (c *AzureRedisClient) GetCache(ctx context.Context, key string) (map[string]string, error) {
-	err := c.redisClient.Ping(ctx).Err()
+func (c *AzureRedisClient) GetCache(ctx context.Context, key string) (cacheData map[string]string, err error) {
+	ctx, span := startSpan(ctx, "AzureRedisClient.GetCache", "azure_redis")
+	defer func() { endSpan(span, err) }()
+	err = traceCommand(ctx, "PING", func(ctx context.Context) error { return c.redisClient.Ping(ctx).Err() })
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect with redis instance: %s", err.Error())
+		return nil, fmt.Errorf("failed to connect with redis instance: %w", err)
+	}
+	err = traceCommand(ctx, "HGETALL", func(ctx context.Context) (err error) {
+		cacheData, err = c.redisClient.HGetAll(ctx, key).Result()
+		return err
+	})
+	return cacheData, err
+}
+
+func (c *AzureRedisClient) TakeTokens(ctx context.Context, key string, burstSize int, tokenDropRate time.Duration, cost int) (tokenNumbers int, lastIncreaseTime time.Time, expireTime time.Duration, err error) {
+	ctx, span := startSpan(ctx, "AzureRedisClient.TakeTokens", "azure_redis")
+	defer func() { endSpan(span, err) }()
+	if err := c.refreshClient(ctx); err != nil {
+		return 0, time.Time{}, 0, err
+	}
+	return takeTokensWithScript(ctx, c.redisClient, key, burstSize, tokenDropRate, cost, 0)
+}
+
+func (c *AzureRedisClient) ReserveTokens(ctx context.Context, key string, burstSize int, tokenDropRate time.Duration, cost int, maxWait time.Duration) (tokenNumbers int, lastIncreaseTime time.Time, expireTime time.Duration, err error) {
+	ctx, span := startSpan(ctx, "AzureRedisClient.ReserveTokens", "azure_redis")
+	defer func() { endSpan(span, err) }()
+	if err := c.refreshClient(ctx); err != nil {
+		return 0, time.Time{}, 0, err
+	}
+	return takeTokensWithScript(ctx, c.redisClient, key, burstSize, tokenDropRate, cost, maxWait)
+}
+
+func (c *AzureRedisClient) RefundTokens(ctx context.Context, key string, burstSize int, tokenDropRate time.Duration, cost int) (tokenNumbers int, lastIncreaseTime time.Time, expireTime time.Duration, err error) {
+	ctx, span := startSpan(ctx, "AzureRedisClient.RefundTokens", "azure_redis")
+	defer func() { endSpan(span, err) }()
+	if err := c.refreshClient(ctx); err != nil {
+		return 0, time.Time{}, 0, err
+	}
+	return refundTokensWithScript(ctx, c.redisClient, key, burstSize, tokenDropRate, cost)
+}
+
+func (c *AzureRedisClient) AcquireLease(ctx context.Context, key string, leaseID string, limit int, ttl time.Duration) (acquired bool, held int, err error) {
+	ctx, span := startSpan(ctx, "AzureRedisClient.AcquireLease", "azure_redis")
+	defer func() { endSpan(span, err) }()
+	if err := c.refreshClient(ctx); err != nil {
+		return false, 0, err
+	}
+	return acquireLeaseWithScript(ctx, c.redisClient, key, leaseID, limit, ttl)
+}
+
+func (c *AzureRedisClient) ExtendLease(ctx context.Context, key string, leaseID string, ttl time.Duration) (extended bool, err error) {
+	ctx, span := startSpan(ctx, "AzureRedisClient.ExtendLease", "azure_redis")
+	defer func() { endSpan(span, err) }()
+	if err := c.refreshClient(ctx); err != nil {
+		return false, err
+	}
+	return extendLeaseWithScript(ctx, c.redisClient, key, leaseID, ttl)
+}
+
+func (c *AzureRedisClient) ReleaseLease(ctx context.Context, key string, leaseID string) (err error) {
+	ctx, span := startSpan(ctx, "AzureRedisClient.ReleaseLease", "azure_redis")
+	defer func() { endSpan(span, err) }()
+	if err := c.refreshClient(ctx); err != nil {
+		return err
 	}
-	return c.redisClient.HGetAll(ctx, key).Result()
+	return releaseLeaseWithScript(ctx, c.redisClient, key, leaseID)
 }
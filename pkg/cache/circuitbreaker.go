@@ -0,0 +1,340 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrCircuitOpen is returned by a circuit breaker client without calling the cache while the circuit is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type CircuitState int
+
+const (
+	// CircuitClosed passes all calls to the cache
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails all calls with ErrCircuitOpen until open timeout passes
+	CircuitOpen
+	// CircuitHalfOpen passes a few probe calls, the circuit is closed when they succeed and opened again when any fails
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+const (
+	DefaultFailureThreshold = 5
+	DefaultOpenTimeout      = 5 * time.Second
+)
+
+// CircuitBreaker tracks failures of a cache, it's shared by the clients wrapped with NewCircuitBreakerClient
+type CircuitBreaker struct {
+	failureThreshold int
+	errorRate        float64
+	minRequests      int
+	errorRateWindow  time.Duration
+	openTimeout      time.Duration
+	halfOpenProbes   int
+	onStateChange    func(from, to CircuitState)
+
+	mu                  sync.Mutex
+	state               CircuitState
+	consecutiveFailures int
+	windowStart         time.Time
+	windowRequests      int
+	windowFailures      int
+	openedAt            time.Time
+	probes              int
+	probeSuccesses      int
+}
+
+type CircuitBreakerOption func(*CircuitBreaker)
+
+// WithFailureThreshold opens the circuit after n consecutive failures, default is 5, 0 disables it
+func WithFailureThreshold(n int) CircuitBreakerOption {
+	return func(b *CircuitBreaker) {
+		b.failureThreshold = n
+	}
+}
+
+// WithErrorRateThreshold opens the circuit when the ratio of failed calls in window reaches rate,
+// the ratio is only checked after minRequests calls in the window, it's disabled by default
+func WithErrorRateThreshold(rate float64, minRequests int, window time.Duration) CircuitBreakerOption {
+	return func(b *CircuitBreaker) {
+		b.errorRate = rate
+		b.minRequests = minRequests
+		b.errorRateWindow = window
+	}
+}
+
+// WithOpenTimeout sets how long the circuit stays open before probing the cache, default is 5s
+func WithOpenTimeout(timeout time.Duration) CircuitBreakerOption {
+	return func(b *CircuitBreaker) {
+		b.openTimeout = timeout
+	}
+}
+
+// WithHalfOpenProbes sets the number of calls passed to the cache in half open state, default is 1
+func WithHalfOpenProbes(n int) CircuitBreakerOption {
+	return func(b *CircuitBreaker) {
+		b.halfOpenProbes = n
+	}
+}
+
+// WithStateChangeHandler is called after the circuit changes state, it must not block
+func WithStateChangeHandler(handler func(from, to CircuitState)) CircuitBreakerOption {
+	return func(b *CircuitBreaker) {
+		b.onStateChange = handler
+	}
+}
+
+func NewCircuitBreaker(opts ...CircuitBreakerOption) *CircuitBreaker {
+	b := &CircuitBreaker{
+		failureThreshold: DefaultFailureThreshold,
+		openTimeout:      DefaultOpenTimeout,
+		halfOpenProbes:   1,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.halfOpenProbes < 1 {
+		b.halfOpenProbes = 1
+	}
+	return b
+}
+
+// State returns current state of the circuit
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.openTimeout {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+// allow returns ErrCircuitOpen when the call can't be passed to the cache, done must be called with the result otherwise
+func (b *CircuitBreaker) allow() error {
+	b.mu.Lock()
+	from := b.state
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.openTimeout {
+		b.state = CircuitHalfOpen
+		b.probes = 0
+		b.probeSuccesses = 0
+	}
+	var err error
+	switch b.state {
+	case CircuitOpen:
+		err = ErrCircuitOpen
+	case CircuitHalfOpen:
+		if b.probes >= b.halfOpenProbes {
+			err = ErrCircuitOpen
+		} else {
+			b.probes++
+		}
+	}
+	to := b.state
+	b.mu.Unlock()
+	b.notify(from, to)
+	return err
+}
+
+func (b *CircuitBreaker) done(err error) {
+	failed := isCacheFailure(err)
+	now := time.Now()
+	b.mu.Lock()
+	from := b.state
+	if errors.Is(err, context.Canceled) {
+		// the caller gave up, the call says nothing about the cache
+		if b.state == CircuitHalfOpen {
+			b.probes--
+		}
+		b.mu.Unlock()
+		return
+	}
+	switch b.state {
+	case CircuitHalfOpen:
+		if failed {
+			b.open(now)
+		} else if b.probeSuccesses++; b.probeSuccesses >= b.halfOpenProbes {
+			b.close(now)
+		}
+	case CircuitClosed:
+		if b.errorRateWindow > 0 && now.Sub(b.windowStart) >= b.errorRateWindow {
+			b.windowStart = now
+			b.windowRequests = 0
+			b.windowFailures = 0
+		}
+		b.windowRequests++
+		if !failed {
+			b.consecutiveFailures = 0
+			break
+		}
+		b.consecutiveFailures++
+		b.windowFailures++
+		if b.failureThreshold > 0 && b.consecutiveFailures >= b.failureThreshold {
+			b.open(now)
+		} else if b.errorRate > 0 && b.windowRequests >= b.minRequests && float64(b.windowFailures) >= b.errorRate*float64(b.windowRequests) {
+			b.open(now)
+		}
+	}
+	// calls which finished after the circuit is opened are ignored
+	to := b.state
+	b.mu.Unlock()
+	b.notify(from, to)
+}
+
+func (b *CircuitBreaker) open(now time.Time) {
+	b.state = CircuitOpen
+	b.openedAt = now
+}
+
+func (b *CircuitBreaker) close(now time.Time) {
+	b.state = CircuitClosed
+	b.consecutiveFailures = 0
+	b.windowStart = now
+	b.windowRequests = 0
+	b.windowFailures = 0
+}
+
+func (b *CircuitBreaker) notify(from, to CircuitState) {
+	if from != to && b.onStateChange != nil {
+		b.onStateChange(from, to)
+	}
+}
+
+// isCacheFailure returns true for transport errors and timeouts, and for redis replies of a server which can't serve requests
+// other errors, e.g. ErrCorruptData, ErrInvalidArgument or a canceled context, are returned by a healthy cache
+func isCacheFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, redis.ErrClosed) {
+		return true
+	}
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) {
+		message := redisErr.Error()
+		for _, prefix := range unavailableRedisErrors {
+			if strings.HasPrefix(message, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	// go-redis doesn't export its pool timeout error
+	return strings.Contains(err.Error(), "redis: connection pool timeout")
+}
+
+// unavailableRedisErrors are prefixes of redis replies when the server is reachable but can't serve requests
+var unavailableRedisErrors = []string{"LOADING ", "READONLY ", "CLUSTERDOWN ", "TRYAGAIN ", "MASTERDOWN ", "ERR max number of clients reached"}
+
+type circuitBreakerClient struct {
+	client  CacheClient
+	breaker *CircuitBreaker
+}
+
+type scriptCircuitBreakerClient struct {
+	circuitBreakerClient
+	scriptClient ScriptCacheClient
+}
+
+// NewCircuitBreakerClient wraps client with breaker, calls fail with ErrCircuitOpen without calling client while the circuit is open
+// the returned client implements the same optional interfaces as redis clients when client implements all of them
+func NewCircuitBreakerClient(client CacheClient, breaker *CircuitBreaker) CacheClient {
+	c := circuitBreakerClient{client: client, breaker: breaker}
+	if scriptClient, ok := client.(ScriptCacheClient); ok {
+		return &scriptCircuitBreakerClient{circuitBreakerClient: c, scriptClient: scriptClient}
+	}
+	return &c
+}
+
+func (c *circuitBreakerClient) UpdateCache(ctx context.Context, key string, cacheData map[string]string, expireTime time.Duration) error {
+	if err := c.breaker.allow(); err != nil {
+		return err
+	}
+	err := c.client.UpdateCache(ctx, key, cacheData, expireTime)
+	c.breaker.done(err)
+	return err
+}
+
+func (c *circuitBreakerClient) GetCache(ctx context.Context, key string) (map[string]string, error) {
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+	cacheData, err := c.client.GetCache(ctx, key)
+	c.breaker.done(err)
+	return cacheData, err
+}
+
+func (c *scriptCircuitBreakerClient) TakeTokens(ctx context.Context, key string, burstSize int, tokenDropRate time.Duration, cost int) (int, time.Time, time.Duration, error) {
+	if err := c.breaker.allow(); err != nil {
+		return 0, time.Time{}, 0, err
+	}
+	tokenNumbers, lastIncreaseTime, expireTime, err := c.scriptClient.TakeTokens(ctx, key, burstSize, tokenDropRate, cost)
+	c.breaker.done(err)
+	return tokenNumbers, lastIncreaseTime, expireTime, err
+}
+
+func (c *scriptCircuitBreakerClient) ReserveTokens(ctx context.Context, key string, burstSize int, tokenDropRate time.Duration, cost int, maxWait time.Duration) (int, time.Time, time.Duration, error) {
+	if err := c.breaker.allow(); err != nil {
+		return 0, time.Time{}, 0, err
+	}
+	tokenNumbers, lastIncreaseTime, expireTime, err := c.scriptClient.ReserveTokens(ctx, key, burstSize, tokenDropRate, cost, maxWait)
+	c.breaker.done(err)
+	return tokenNumbers, lastIncreaseTime, expireTime, err
+}
+
+func (c *scriptCircuitBreakerClient) RefundTokens(ctx context.Context, key string, burstSize int, tokenDropRate time.Duration, cost int) (int, time.Time, time.Duration, error) {
+	if err := c.breaker.allow(); err != nil {
+		return 0, time.Time{}, 0, err
+	}
+	tokenNumbers, lastIncreaseTime, expireTime, err := c.scriptClient.RefundTokens(ctx, key, burstSize, tokenDropRate, cost)
+	c.breaker.done(err)
+	return tokenNumbers, lastIncreaseTime, expireTime, err
+}
+
+func (c *scriptCircuitBreakerClient) AcquireLease(ctx context.Context, key string, leaseID string, limit int, ttl time.Duration) (bool, int, error) {
+	if err := c.breaker.allow(); err != nil {
+		return false, 0, err
+	}
+	acquired, inFlight, err := c.scriptClient.AcquireLease(ctx, key, leaseID, limit, ttl)
+	c.breaker.done(err)
+	return acquired, inFlight, err
+}
+
+func (c *scriptCircuitBreakerClient) ExtendLease(ctx context.Context, key string, leaseID string, ttl time.Duration) (bool, error) {
+	if err := c.breaker.allow(); err != nil {
+		return false, err
+	}
+	extended, err := c.scriptClient.ExtendLease(ctx, key, leaseID, ttl)
+	c.breaker.done(err)
+	return extended, err
+}
+
+func (c *scriptCircuitBreakerClient) ReleaseLease(ctx context.Context, key string, leaseID string) error {
+	if err := c.breaker.allow(); err != nil {
+		return err
+	}
+	err := c.scriptClient.ReleaseLease(ctx, key, leaseID)
+	c.breaker.done(err)
+	return err
+}
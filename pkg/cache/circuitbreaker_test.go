@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCacheClient struct {
+	err   error
+	calls int
+}
+
+func (c *testCacheClient) UpdateCache(ctx context.Context, key string, cacheData map[string]string, expireTime time.Duration) error {
+	c.calls++
+	return c.err
+}
+
+func (c *testCacheClient) GetCache(ctx context.Context, key string) (map[string]string, error) {
+	c.calls++
+	return nil, c.err
+}
+
+var errConnectionRefused = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+func TestCircuitBreakerConsecutiveFailures(t *testing.T) {
+	ctx := context.Background()
+	var changes []CircuitState
+	breaker := NewCircuitBreaker(WithFailureThreshold(2), WithOpenTimeout(50*time.Millisecond),
+		WithStateChangeHandler(func(from, to CircuitState) { changes = append(changes, to) }))
+	inner := &testCacheClient{err: errConnectionRefused}
+	client := NewCircuitBreakerClient(inner, breaker)
+
+	for i := 0; i < 2; i++ {
+		_, err := client.GetCache(ctx, "id1")
+		assert.NotErrorIs(t, err, ErrCircuitOpen)
+	}
+	assert.Equal(t, CircuitOpen, breaker.State())
+	// the cache isn't called while the circuit is open
+	_, err := client.GetCache(ctx, "id1")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 2, inner.calls)
+
+	// failed probe opens the circuit again
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, CircuitHalfOpen, breaker.State())
+	_, err = client.GetCache(ctx, "id1")
+	assert.NotErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, CircuitOpen, breaker.State())
+
+	time.Sleep(60 * time.Millisecond)
+	inner.err = nil
+	assert.Nil(t, client.UpdateCache(ctx, "id1", nil, time.Minute))
+	assert.Equal(t, CircuitClosed, breaker.State())
+	assert.Equal(t, []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}, changes)
+}
+
+func TestCircuitBreakerErrorRate(t *testing.T) {
+	ctx := context.Background()
+	breaker := NewCircuitBreaker(WithFailureThreshold(0), WithErrorRateThreshold(0.5, 4, time.Minute))
+	inner := &testCacheClient{}
+	client := NewCircuitBreakerClient(inner, breaker)
+
+	for _, err := range []error{nil, errConnectionRefused, nil} {
+		inner.err = err
+		_, _ = client.GetCache(ctx, "id1")
+		assert.Equal(t, CircuitClosed, breaker.State())
+	}
+	inner.err = errConnectionRefused
+	_, _ = client.GetCache(ctx, "id1")
+	assert.Equal(t, CircuitOpen, breaker.State())
+}
+
+func TestCircuitBreakerIgnoredErrors(t *testing.T) {
+	ctx := context.Background()
+	breaker := NewCircuitBreaker(WithFailureThreshold(1))
+	inner := &testCacheClient{err: context.Canceled}
+	client := NewCircuitBreakerClient(inner, breaker)
+
+	_, _ = client.GetCache(ctx, "id1")
+	assert.Equal(t, CircuitClosed, breaker.State())
+	inner.err = ErrCorruptData
+	_, _ = client.GetCache(ctx, "id1")
+	assert.Equal(t, CircuitClosed, breaker.State())
+	// only transport errors count, not errors of a key or its arguments
+	inner.err = fmt.Errorf("%w: lease ttl must be at least 1ms", ErrInvalidArgument)
+	_, _ = client.GetCache(ctx, "id1")
+	assert.Equal(t, CircuitClosed, breaker.State())
+	inner.err = errors.New("unexpected token bucket script result []")
+	_, _ = client.GetCache(ctx, "id1")
+	assert.Equal(t, CircuitClosed, breaker.State())
+	inner.err = fmt.Errorf("failed to connect with redis instance: %w", context.DeadlineExceeded)
+	_, _ = client.GetCache(ctx, "id1")
+	assert.Equal(t, CircuitOpen, breaker.State())
+}
+
+func TestCircuitBreakerScriptArgumentErrors(t *testing.T) {
+	_, redisClient := newTestRedisClient(t)
+	ctx := context.Background()
+	breaker := NewCircuitBreaker(WithFailureThreshold(1))
+	client := NewCircuitBreakerClient(redisClient, breaker)
+
+	_, _, _, err := client.(TokenBucketRefundCacheClient).RefundTokens(ctx, "id1", 2, time.Minute, 0)
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+	_, _, err = client.(ConcurrencyCacheClient).AcquireLease(ctx, "id1", "lease1", 2, time.Microsecond)
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+	assert.Equal(t, CircuitClosed, breaker.State())
+	// other keys still use the cache
+	_, _, _, err = client.(TokenBucketCacheClient).TakeTokens(ctx, "id2", 2, 500*time.Microsecond, 1)
+	assert.Nil(t, err)
+}
+
+func TestCircuitBreakerClientInterfaces(t *testing.T) {
+	server, redisClient := newTestRedisClient(t)
+	ctx := context.Background()
+	breaker := NewCircuitBreaker(WithFailureThreshold(1))
+
+	client := NewCircuitBreakerClient(redisClient, breaker)
+	assert.Implements(t, (*TokenBucketCacheClient)(nil), client)
+	assert.Implements(t, (*ConcurrencyCacheClient)(nil), client)
+	tokenNumbers, _, _, err := client.(TokenBucketCacheClient).TakeTokens(ctx, "id1", 2, time.Minute, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, tokenNumbers)
+
+	server.Close()
+	_, _, _, err = client.(TokenBucketCacheClient).TakeTokens(ctx, "id1", 2, time.Minute, 1)
+	assert.NotErrorIs(t, err, ErrCircuitOpen)
+	_, _, err = client.(ConcurrencyCacheClient).AcquireLease(ctx, "id2", "lease1", 1, time.Minute)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	// memcache has no optional interfaces
+	_, ok := NewCircuitBreakerClient(NewMemCacheClient(time.Minute, time.Minute), breaker).(TokenBucketCacheClient)
+	assert.False(t, ok)
+}
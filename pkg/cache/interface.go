@@ -2,10 +2,58 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
+// ErrCorruptData is returned by cache clients when the saved state can't be parsed
+var ErrCorruptData = errors.New("corrupt data in cache")
+
+// ErrInvalidArgument is returned by cache clients for arguments they can't run with, e.g. a lease ttl below 1ms,
+// the cache isn't called and is still healthy
+var ErrInvalidArgument = errors.New("invalid cache argument")
+
 type CacheClient interface {
 	UpdateCache(ctx context.Context, key string, cacheData map[string]string, expireTime time.Duration) error
 	GetCache(ctx context.Context, key string) (map[string]string, error)
 }
+
+// TokenBucketCacheClient is implemented by cache clients which can take tokens from a token bucket atomically on the server,
+// instead of reading the bucket with GetCache and writing it back with UpdateCache
+// return the same values as algorithm.Bucket.TakeTokens: token number after taking, last time token increase, bucket expire time, error
+// the bucket is only updated when token number >= 0
+type TokenBucketCacheClient interface {
+	TakeTokens(ctx context.Context, key string, burstSize int, tokenDropRate time.Duration, cost int) (int, time.Time, time.Duration, error)
+}
+
+// TokenBucketReserveCacheClient is implemented by cache clients which can reserve tokens of a token bucket atomically on the server
+// return the same values as algorithm.Bucket.ReserveTokens
+type TokenBucketReserveCacheClient interface {
+	ReserveTokens(ctx context.Context, key string, burstSize int, tokenDropRate time.Duration, cost int, maxWait time.Duration) (int, time.Time, time.Duration, error)
+}
+
+// TokenBucketRefundCacheClient is implemented by cache clients which can refund tokens to a token bucket atomically on the server
+// return the same values as algorithm.Bucket.RefundTokens
+type TokenBucketRefundCacheClient interface {
+	RefundTokens(ctx context.Context, key string, burstSize int, tokenDropRate time.Duration, cost int) (int, time.Time, time.Duration, error)
+}
+
+// ConcurrencyCacheClient is implemented by cache clients which can acquire leases atomically on the server
+// leases are saved in a hash of lease id to expire time in unix milliseconds, an expired lease is not counted
+type ConcurrencyCacheClient interface {
+	// AcquireLease adds the lease when less than limit leases are held, return if it's acquired and number of leases held
+	AcquireLease(ctx context.Context, key string, leaseID string, limit int, ttl time.Duration) (bool, int, error)
+	// ExtendLease sets expire time of a held lease to ttl from now, return false when the lease is expired or released
+	ExtendLease(ctx context.Context, key string, leaseID string, ttl time.Duration) (bool, error)
+	ReleaseLease(ctx context.Context, key string, leaseID string) error
+}
+
+// ScriptCacheClient is implemented by redis clients which run lua scripts on the server,
+// wrappers of cache clients implement it when the wrapped client does
+type ScriptCacheClient interface {
+	CacheClient
+	TokenBucketCacheClient
+	TokenBucketReserveCacheClient
+	TokenBucketRefundCacheClient
+	ConcurrencyCacheClient
+}
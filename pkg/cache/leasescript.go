@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// acquireLeaseScript drops expired leases and adds a new one when less than limit leases are held, in one server side call
+// leases are saved in a hash of lease id to expire time in unix milliseconds, the key expires with the last lease
+// KEYS[1]: lease key
+// ARGV[1]: max number of leases
+// ARGV[2]: lease id
+// ARGV[3]: current unix time in milliseconds
+// ARGV[4]: lease ttl in milliseconds
+// return: 1 if acquired, number of leases held after acquiring
+var acquireLeaseScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local now = tonumber(ARGV[3])
+local expireAt = now + tonumber(ARGV[4])
+local leases = redis.call('HGETALL', KEYS[1])
+local held = 0
+local lastExpireAt = expireAt
+for i = 1, #leases, 2 do
+	local leaseExpireAt = tonumber(leases[i + 1])
+	if leaseExpireAt == nil or leaseExpireAt <= now then
+		redis.call('HDEL', KEYS[1], leases[i])
+	elseif leases[i] ~= ARGV[2] then
+		held = held + 1
+		lastExpireAt = math.max(lastExpireAt, leaseExpireAt)
+	end
+end
+if held >= limit then
+	return {0, held}
+end
+redis.call('HSET', KEYS[1], ARGV[2], expireAt)
+redis.call('PEXPIRE', KEYS[1], lastExpireAt - now)
+return {1, held + 1}
+`)
+
+// extendLeaseScript moves expire time of a lease which is still held
+// KEYS[1]: lease key
+// ARGV[1]: lease id
+// ARGV[2]: current unix time in milliseconds
+// ARGV[3]: lease ttl in milliseconds
+// return: 1 if extended, 0 if the lease is expired or released
+var extendLeaseScript = redis.NewScript(`
+local now = tonumber(ARGV[2])
+local ttl = tonumber(ARGV[3])
+local leaseExpireAt = tonumber(redis.call('HGET', KEYS[1], ARGV[1]))
+if leaseExpireAt == nil or leaseExpireAt <= now then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], now + ttl)
+if redis.call('PTTL', KEYS[1]) < ttl then
+	redis.call('PEXPIRE', KEYS[1], ttl)
+end
+return 1
+`)
+
+var releaseLeaseScript = redis.NewScript(`
+return redis.call('HDEL', KEYS[1], ARGV[1])
+`)
+
+// acquireLeaseWithScript runs acquireLeaseScript, return if the lease is acquired and number of leases held
+func acquireLeaseWithScript(ctx context.Context, scripter redis.Scripter, key string, leaseID string, limit int, ttl time.Duration) (bool, int, error) {
+	if ttl < time.Millisecond {
+		return false, 0, fmt.Errorf("%w: lease ttl must be at least 1ms", ErrInvalidArgument)
+	}
+	result, err := acquireLeaseScript.Run(ctx, scripter, []string{key}, limit, leaseID, time.Now().UnixMilli(), ttl.Milliseconds()).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(result) != 2 {
+		return false, 0, fmt.Errorf("unexpected acquire lease script result %v", result)
+	}
+	return result[0] == 1, int(result[1]), nil
+}
+
+func extendLeaseWithScript(ctx context.Context, scripter redis.Scripter, key string, leaseID string, ttl time.Duration) (bool, error) {
+	if ttl < time.Millisecond {
+		return false, fmt.Errorf("%w: lease ttl must be at least 1ms", ErrInvalidArgument)
+	}
+	extended, err := extendLeaseScript.Run(ctx, scripter, []string{key}, leaseID, time.Now().UnixMilli(), ttl.Milliseconds()).Int()
+	return extended == 1, err
+}
+
+func releaseLeaseWithScript(ctx context.Context, scripter redis.Scripter, key string, leaseID string) error {
+	return releaseLeaseScript.Run(ctx, scripter, []string{key}, leaseID).Err()
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcquireLeaseWithScript(t *testing.T) {
+	server, client := newTestRedisClient(t)
+	ctx := context.Background()
+
+	for i, id := range []string{"lease1", "lease2"} {
+		acquired, held, err := client.AcquireLease(ctx, "id1", id, 2, time.Minute)
+		assert.Nil(t, err)
+		assert.True(t, acquired)
+		assert.Equal(t, i+1, held)
+	}
+	acquired, held, err := client.AcquireLease(ctx, "id1", "lease3", 2, time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, acquired)
+	assert.Equal(t, 2, held)
+	assert.InDelta(t, float64(time.Minute), float64(server.TTL("id1")), float64(time.Second))
+
+	assert.Nil(t, client.ReleaseLease(ctx, "id1", "lease1"))
+	acquired, _, err = client.AcquireLease(ctx, "id1", "lease3", 2, time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+}
+
+func TestAcquireLeaseWithScriptExpired(t *testing.T) {
+	server, client := newTestRedisClient(t)
+	ctx := context.Background()
+
+	// lease of a crashed replica
+	server.HSet("id1", "lease1", "1")
+	acquired, held, err := client.AcquireLease(ctx, "id1", "lease2", 1, time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+	assert.Equal(t, 1, held)
+	keys, err := server.HKeys("id1")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"lease2"}, keys)
+
+	extended, err := client.ExtendLease(ctx, "id1", "lease2", 2*time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, extended)
+	assert.InDelta(t, float64(2*time.Minute), float64(server.TTL("id1")), float64(time.Second))
+
+	extended, err = client.ExtendLease(ctx, "id1", "lease1", time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, extended)
+}
@@ -18,11 +18,15 @@ func NewMemCacheClient(defaultExpireTime, defaultPurgeTime time.Duration) *MemCa
 }
 
 func (c MemCacheClient) UpdateCache(ctx context.Context, key string, cacheData map[string]string, expireTime time.Duration) error {
+	_, span := startSpan(ctx, "MemCacheClient.UpdateCache", "memory")
+	defer span.End()
 	c.memCache.Set(key, cacheData, expireTime)
 	return nil
 }
 
 func (c MemCacheClient) GetCache(ctx context.Context, key string) (map[string]string, error) {
+	_, span := startSpan(ctx, "MemCacheClient.GetCache", "memory")
+	defer span.End()
 	cacheData, found := c.memCache.Get(key)
 	if !found {
 		return nil, nil
@@ -30,3 +34,8 @@ func (c MemCacheClient) GetCache(ctx context.Context, key string) (map[string]st
 	return cacheData.(map[string]string), nil
 
 }
+
+// ItemCount returns number of items in memcache, including expired items which aren't purged yet
+func (c MemCacheClient) ItemCount() int {
+	return c.memCache.ItemCount()
+}
@@ -18,12 +18,14 @@ func NewRedisClient(ctx context.Context, client *redis.Client) *RedisClient {
 	}
 }
 
-func (c *RedisClient) UpdateCache(ctx context.Context, key string, cacheData map[string]string, expireTime time.Duration) error {
-	err := c.client.Ping(ctx).Err()
+func (c *RedisClient) UpdateCache(ctx context.Context, key string, cacheData map[string]string, expireTime time.Duration) (err error) {
+	ctx, span := startSpan(ctx, "RedisClient.UpdateCache", "redis")
+	defer func() { endSpan(span, err) }()
+	err = traceCommand(ctx, "PING", func(ctx context.Context) error { return c.client.Ping(ctx).Err() })
 	if err != nil {
-		return fmt.Errorf("failed to connect with redis instance: %s", err.Error())
+		return fmt.Errorf("failed to connect with redis instance: %w", err)
 	}
-	_, err = c.client.HSet(ctx, key, cacheData).Result()
+	err = traceCommand(ctx, "HSET", func(ctx context.Context) error { return c.client.HSet(ctx, key, cacheData).Err() })
 	if err != nil {
 		return err
 	}
@@ -31,18 +33,60 @@ func (c *RedisClient) UpdateCache(ctx context.Context, key string, cacheData map
 	return nil
 }
 
-func (c *RedisClient) GetCache(ctx context.Context, key string) (map[string]string, error) {
-	err := c.client.Ping(ctx).Err()
+func (c *RedisClient) GetCache(ctx context.Context, key string) (cacheData map[string]string, err error) {
+	ctx, span := startSpan(ctx, "RedisClient.GetCache", "redis")
+	defer func() { endSpan(span, err) }()
+	err = traceCommand(ctx, "PING", func(ctx context.Context) error { return c.client.Ping(ctx).Err() })
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect with redis instance: %s", err.Error())
+		return nil, fmt.Errorf("failed to connect with redis instance: %w", err)
 	}
-	return c.client.HGetAll(ctx, key).Result()
+	err = traceCommand(ctx, "HGETALL", func(ctx context.Context) (err error) {
+		cacheData, err = c.client.HGetAll(ctx, key).Result()
+		return err
+	})
+	return cacheData, err
+}
+
+func (c *RedisClient) TakeTokens(ctx context.Context, key string, burstSize int, tokenDropRate time.Duration, cost int) (tokenNumbers int, lastIncreaseTime time.Time, expireTime time.Duration, err error) {
+	ctx, span := startSpan(ctx, "RedisClient.TakeTokens", "redis")
+	defer func() { endSpan(span, err) }()
+	return takeTokensWithScript(ctx, c.client, key, burstSize, tokenDropRate, cost, 0)
+}
+
+func (c *RedisClient) ReserveTokens(ctx context.Context, key string, burstSize int, tokenDropRate time.Duration, cost int, maxWait time.Duration) (tokenNumbers int, lastIncreaseTime time.Time, expireTime time.Duration, err error) {
+	ctx, span := startSpan(ctx, "RedisClient.ReserveTokens", "redis")
+	defer func() { endSpan(span, err) }()
+	return takeTokensWithScript(ctx, c.client, key, burstSize, tokenDropRate, cost, maxWait)
+}
+
+func (c *RedisClient) RefundTokens(ctx context.Context, key string, burstSize int, tokenDropRate time.Duration, cost int) (tokenNumbers int, lastIncreaseTime time.Time, expireTime time.Duration, err error) {
+	ctx, span := startSpan(ctx, "RedisClient.RefundTokens", "redis")
+	defer func() { endSpan(span, err) }()
+	return refundTokensWithScript(ctx, c.client, key, burstSize, tokenDropRate, cost)
+}
+
+func (c *RedisClient) AcquireLease(ctx context.Context, key string, leaseID string, limit int, ttl time.Duration) (acquired bool, held int, err error) {
+	ctx, span := startSpan(ctx, "RedisClient.AcquireLease", "redis")
+	defer func() { endSpan(span, err) }()
+	return acquireLeaseWithScript(ctx, c.client, key, leaseID, limit, ttl)
+}
+
+func (c *RedisClient) ExtendLease(ctx context.Context, key string, leaseID string, ttl time.Duration) (extended bool, err error) {
+	ctx, span := startSpan(ctx, "RedisClient.ExtendLease", "redis")
+	defer func() { endSpan(span, err) }()
+	return extendLeaseWithScript(ctx, c.client, key, leaseID, ttl)
+}
+
+func (c *RedisClient) ReleaseLease(ctx context.Context, key string, leaseID string) (err error) {
+	ctx, span := startSpan(ctx, "RedisClient.ReleaseLease", "redis")
+	defer func() { endSpan(span, err) }()
+	return releaseLeaseWithScript(ctx, c.client, key, leaseID)
 }
 
 func (c *RedisClient) GetMemoryUsage(ctx context.Context, key string) (int64, error) {
 	err := c.client.Ping(ctx).Err()
 	if err != nil {
-		return 0, fmt.Errorf("failed to connect with redis instance: %s", err.Error())
+		return 0, fmt.Errorf("failed to connect with redis instance: %w", err)
 	}
 	return c.client.MemoryUsage(ctx, key).Result()
 }
@@ -17,8 +17,10 @@ func NewClusterClient(client *redis.ClusterClient) *RedisClusterCacheClient {
 	}
 }
 
-func (c *RedisClusterCacheClient) UpdateCache(ctx context.Context, key string, cacheData map[string]string, expireTime time.Duration) error {
-	_, err := c.client.HSet(ctx, key, cacheData).Result()
+func (c *RedisClusterCacheClient) UpdateCache(ctx context.Context, key string, cacheData map[string]string, expireTime time.Duration) (err error) {
+	ctx, span := startSpan(ctx, "RedisClusterCacheClient.UpdateCache", "redis_cluster")
+	defer func() { endSpan(span, err) }()
+	err = traceCommand(ctx, "HSET", func(ctx context.Context) error { return c.client.HSet(ctx, key, cacheData).Err() })
 	if err != nil {
 		return err
 	}
@@ -26,8 +28,50 @@ func (c *RedisClusterCacheClient) UpdateCache(ctx context.Context, key string, c
 	return nil
 }
 
-func (c *RedisClusterCacheClient) GetCache(ctx context.Context, key string) (map[string]string, error) {
-	return c.client.HGetAll(ctx, key).Result()
+func (c *RedisClusterCacheClient) GetCache(ctx context.Context, key string) (cacheData map[string]string, err error) {
+	ctx, span := startSpan(ctx, "RedisClusterCacheClient.GetCache", "redis_cluster")
+	defer func() { endSpan(span, err) }()
+	err = traceCommand(ctx, "HGETALL", func(ctx context.Context) (err error) {
+		cacheData, err = c.client.HGetAll(ctx, key).Result()
+		return err
+	})
+	return cacheData, err
+}
+
+func (c *RedisClusterCacheClient) TakeTokens(ctx context.Context, key string, burstSize int, tokenDropRate time.Duration, cost int) (tokenNumbers int, lastIncreaseTime time.Time, expireTime time.Duration, err error) {
+	ctx, span := startSpan(ctx, "RedisClusterCacheClient.TakeTokens", "redis_cluster")
+	defer func() { endSpan(span, err) }()
+	return takeTokensWithScript(ctx, c.client, key, burstSize, tokenDropRate, cost, 0)
+}
+
+func (c *RedisClusterCacheClient) ReserveTokens(ctx context.Context, key string, burstSize int, tokenDropRate time.Duration, cost int, maxWait time.Duration) (tokenNumbers int, lastIncreaseTime time.Time, expireTime time.Duration, err error) {
+	ctx, span := startSpan(ctx, "RedisClusterCacheClient.ReserveTokens", "redis_cluster")
+	defer func() { endSpan(span, err) }()
+	return takeTokensWithScript(ctx, c.client, key, burstSize, tokenDropRate, cost, maxWait)
+}
+
+func (c *RedisClusterCacheClient) RefundTokens(ctx context.Context, key string, burstSize int, tokenDropRate time.Duration, cost int) (tokenNumbers int, lastIncreaseTime time.Time, expireTime time.Duration, err error) {
+	ctx, span := startSpan(ctx, "RedisClusterCacheClient.RefundTokens", "redis_cluster")
+	defer func() { endSpan(span, err) }()
+	return refundTokensWithScript(ctx, c.client, key, burstSize, tokenDropRate, cost)
+}
+
+func (c *RedisClusterCacheClient) AcquireLease(ctx context.Context, key string, leaseID string, limit int, ttl time.Duration) (acquired bool, held int, err error) {
+	ctx, span := startSpan(ctx, "RedisClusterCacheClient.AcquireLease", "redis_cluster")
+	defer func() { endSpan(span, err) }()
+	return acquireLeaseWithScript(ctx, c.client, key, leaseID, limit, ttl)
+}
+
+func (c *RedisClusterCacheClient) ExtendLease(ctx context.Context, key string, leaseID string, ttl time.Duration) (extended bool, err error) {
+	ctx, span := startSpan(ctx, "RedisClusterCacheClient.ExtendLease", "redis_cluster")
+	defer func() { endSpan(span, err) }()
+	return extendLeaseWithScript(ctx, c.client, key, leaseID, ttl)
+}
+
+func (c *RedisClusterCacheClient) ReleaseLease(ctx context.Context, key string, leaseID string) (err error) {
+	ctx, span := startSpan(ctx, "RedisClusterCacheClient.ReleaseLease", "redis_cluster")
+	defer func() { endSpan(span, err) }()
+	return releaseLeaseWithScript(ctx, c.client, key, leaseID)
 }
 
 func (c *RedisClusterCacheClient) GetMemoryUsage(ctx context.Context, key string) (int64, error) {
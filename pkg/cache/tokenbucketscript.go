@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const maxScriptWait = 100 * 365 * 24 * time.Hour
+
+// tokenBucketScript refills, takes tokens from and expires a token bucket in one server side call,
+// so replicas sharing the same key can't both take the last token.
+// the bucket is saved in the same hash format as algorithm.Bucket uses: token number and last increase time in RFC3339 with microseconds
+// times are in microseconds, which keeps unix time within the exact integer range of lua numbers,
+// the rate is passed in nanoseconds so rates below 1ms or not in whole milliseconds refill at the configured rate
+// KEYS[1]: bucket key
+// ARGV[1]: burst size
+// ARGV[2]: token drop rate in nanoseconds
+// ARGV[3]: current unix time in microseconds
+// ARGV[4]: number of tokens to take, negative to refund tokens
+// ARGV[5]: max time in microseconds to borrow missing tokens from the future, 0 to only take available tokens
+// return: token number after taking, last increase time in unix microseconds, expire time in microseconds, 1 if cached data is wrong
+var tokenBucketScript = redis.NewScript(`
+local burstSize = tonumber(ARGV[1])
+-- in microseconds, may have a fraction
+local tokenDropRate = tonumber(ARGV[2]) / 1000
+local now = tonumber(ARGV[3])
+local cost = tonumber(ARGV[4])
+local maxWait = tonumber(ARGV[5])
+
+local function daysFromCivil(y, m, d)
+	if m <= 2 then
+		y = y - 1
+	end
+	local era = math.floor(y / 400)
+	local yoe = y - era * 400
+	local doy = math.floor((153 * ((m + 9) % 12) + 2) / 5) + d - 1
+	local doe = yoe * 365 + math.floor(yoe / 4) - math.floor(yoe / 100) + doy
+	return era * 146097 + doe - 719468
+end
+
+local function civilFromDays(z)
+	z = z + 719468
+	local era = math.floor(z / 146097)
+	local doe = z - era * 146097
+	local yoe = math.floor((doe - math.floor(doe / 1460) + math.floor(doe / 36524) - math.floor(doe / 146096)) / 365)
+	local doy = doe - (365 * yoe + math.floor(yoe / 4) - math.floor(yoe / 100))
+	local mp = math.floor((5 * doy + 2) / 153)
+	local d = doy - math.floor((153 * mp + 2) / 5) + 1
+	local m = mp + 3
+	if mp >= 10 then
+		m = mp - 9
+	end
+	local y = yoe + era * 400
+	if m <= 2 then
+		y = y + 1
+	end
+	return y, m, d
+end
+
+-- parse RFC3339 time to unix microseconds
+local function parseTime(value)
+	if not value then
+		return nil
+	end
+	local y, mo, d, h, mi, s, frac, zone = string.match(value, '^(%d%d%d%d)%-(%d%d)%-(%d%d)T(%d%d):(%d%d):(%d%d)(%.?%d*)(.*)$')
+	if not y then
+		return nil
+	end
+	local offset = 0
+	if zone ~= 'Z' then
+		local sign, oh, om = string.match(zone, '^([%+%-])(%d%d):(%d%d)$')
+		if not sign then
+			return nil
+		end
+		offset = (tonumber(oh) * 60 + tonumber(om)) * 60
+		if sign == '-' then
+			offset = -offset
+		end
+	end
+	local seconds = daysFromCivil(tonumber(y), tonumber(mo), tonumber(d)) * 86400 + tonumber(h) * 3600 + tonumber(mi) * 60 + tonumber(s) - offset
+	local us = seconds * 1000000
+	if string.len(frac) > 1 then
+		-- round to the closest microsecond, the float fraction isn't exact
+		us = us + math.floor(tonumber('0' .. frac) * 1000000 + 0.5)
+	end
+	return us
+end
+
+-- format unix microseconds to RFC3339 time with microseconds in UTC
+local function formatTime(us)
+	local seconds = math.floor(us / 1000000)
+	local days = math.floor(seconds / 86400)
+	local rem = seconds - days * 86400
+	local y, m, d = civilFromDays(days)
+	return string.format('%04d-%02d-%02dT%02d:%02d:%02d.%06dZ', y, m, d, math.floor(rem / 3600), math.floor((rem % 3600) / 60), rem % 60, us - seconds * 1000000)
+end
+
+local tokens = burstSize
+local lastIncreaseTime = now
+local wrongData = 0
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'tokenLastIncreaseTime')
+if state[1] or state[2] then
+	local savedTokens = tonumber(state[1])
+	local savedTime = parseTime(state[2])
+	if savedTokens == nil or savedTokens < 0 or savedTokens ~= math.floor(savedTokens) or savedTime == nil then
+		-- start a new bucket but report the wrong data
+		wrongData = 1
+	else
+		local increase = 0
+		if now > savedTime then
+			increase = math.floor((now - savedTime) / tokenDropRate)
+		end
+		tokens = math.min(savedTokens + increase, burstSize)
+		lastIncreaseTime = savedTime + increase * tokenDropRate
+	end
+end
+
+if cost < 0 and lastIncreaseTime > now then
+	-- give back tokens reserved ahead first, the next reservation is due earlier
+	local returned = math.min(-cost, math.ceil((lastIncreaseTime - now) / tokenDropRate))
+	lastIncreaseTime = lastIncreaseTime - returned * tokenDropRate
+	cost = cost + returned
+end
+-- refunded tokens never exceed burst size
+tokens = math.min(tokens - cost, burstSize)
+if tokens < 0 and maxWait > 0 then
+	-- reserve missing tokens, last increase time moves to the time the last one is added
+	local readyAt = lastIncreaseTime + (-tokens) * tokenDropRate
+	if readyAt - now <= maxWait then
+		tokens = 0
+		lastIncreaseTime = readyAt
+	end
+end
+-- round up to whole microseconds, so the bucket never refills faster than the rate
+lastIncreaseTime = math.ceil(lastIncreaseTime)
+local expireTime = 0
+if tokens >= 0 then
+	expireTime = math.ceil(lastIncreaseTime + (burstSize - tokens) * tokenDropRate - now)
+	if wrongData == 0 then
+		redis.call('HSET', KEYS[1], 'tokens', tokens, 'tokenLastIncreaseTime', formatTime(lastIncreaseTime))
+		redis.call('PEXPIRE', KEYS[1], math.max(math.ceil(expireTime / 1000), 1))
+	end
+end
+return {tokens, lastIncreaseTime, expireTime, wrongData}
+`)
+
+// takeTokensWithScript runs tokenBucketScript and converts its result to the same values algorithm.Bucket.ReserveTokens returns
+func takeTokensWithScript(ctx context.Context, scripter redis.Scripter, key string, burstSize int, tokenDropRate time.Duration, cost int, maxWait time.Duration) (int, time.Time, time.Duration, error) {
+	if tokenDropRate <= 0 {
+		return 0, time.Time{}, 0, fmt.Errorf("%w: token drop rate must be greater than 0", ErrInvalidArgument)
+	}
+	// lua numbers are float64, keep unlimited wait within the exact integer range
+	maxWait = min(maxWait, maxScriptWait)
+	result, err := tokenBucketScript.Run(ctx, scripter, []string{key}, burstSize, int64(tokenDropRate), time.Now().UnixMicro(), cost, maxWait.Microseconds()).Int64Slice()
+	if err != nil {
+		return 0, time.Time{}, 0, err
+	}
+	if len(result) != 4 {
+		return 0, time.Time{}, 0, fmt.Errorf("unexpected token bucket script result %v", result)
+	}
+	tokenNumbers := int(result[0])
+	lastIncreaseTime := time.UnixMicro(result[1])
+	expireTime := time.Duration(result[2]) * time.Microsecond
+	if result[3] == 1 {
+		return tokenNumbers, lastIncreaseTime, expireTime, fmt.Errorf("%w for key %s", ErrCorruptData, key)
+	}
+	return tokenNumbers, lastIncreaseTime, expireTime, nil
+}
+
+// refundTokensWithScript runs tokenBucketScript with a negative cost and returns the same values algorithm.Bucket.RefundTokens returns
+func refundTokensWithScript(ctx context.Context, scripter redis.Scripter, key string, burstSize int, tokenDropRate time.Duration, cost int) (int, time.Time, time.Duration, error) {
+	if cost <= 0 {
+		return 0, time.Time{}, 0, fmt.Errorf("%w: cost must be greater than 0", ErrInvalidArgument)
+	}
+	return takeTokensWithScript(ctx, scripter, key, burstSize, tokenDropRate, -cost, 0)
+}
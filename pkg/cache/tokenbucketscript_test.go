@@ -0,0 +1,217 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRedisClient(t *testing.T) (*miniredis.Miniredis, *RedisClient) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return server, NewRedisClient(context.Background(), client)
+}
+
+func TestTakeTokenWithScriptNewBucket(t *testing.T) {
+	server, client := newTestRedisClient(t)
+	ctx := context.Background()
+
+	tokenNumbers, lastIncreaseTime, expireTime, err := client.TakeTokens(ctx, "id1", 10, 30*time.Second, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 9, tokenNumbers)
+	assert.True(t, lastIncreaseTime.After(time.Now().Add(-time.Second)))
+	// 1 token to be full
+	assert.InDelta(t, float64(30*time.Second), float64(expireTime), float64(time.Second))
+
+	// saved in the same format as the read and update path
+	currentCache, err := client.GetCache(ctx, "id1")
+	assert.Nil(t, err)
+	assert.Equal(t, "9", currentCache["tokens"])
+	savedLastIncreaseTime, err := time.Parse(time.RFC3339, currentCache["tokenLastIncreaseTime"])
+	assert.Nil(t, err)
+	assert.Equal(t, lastIncreaseTime.UnixMilli(), savedLastIncreaseTime.UnixMilli())
+	assert.InDelta(t, float64(30*time.Second), float64(server.TTL("id1")), float64(time.Second))
+}
+
+func TestTakeTokenWithScriptRefill(t *testing.T) {
+	_, client := newTestRedisClient(t)
+	ctx := context.Background()
+
+	// saved by another replica in a different time zone a minute ago
+	lastIncreaseTime := time.Now().Add(-time.Minute).In(time.FixedZone("", -5*60*60))
+	err := client.UpdateCache(ctx, "id1", map[string]string{
+		"tokens":                "5",
+		"tokenLastIncreaseTime": lastIncreaseTime.Format(time.RFC3339),
+	}, time.Hour)
+	assert.Nil(t, err)
+
+	tokenNumbers, newLastIncreaseTime, _, err := client.TakeTokens(ctx, "id1", 10, 30*time.Second, 1)
+	assert.Nil(t, err)
+	// 2 tokens added, 1 taken
+	assert.Equal(t, 6, tokenNumbers)
+	assert.True(t, newLastIncreaseTime.After(time.Now().Add(-time.Second*2)))
+}
+
+func TestTakeTokenWithScriptSubMillisecondRate(t *testing.T) {
+	_, client := newTestRedisClient(t)
+	ctx := context.Background()
+
+	// rates below 1ms or not in whole milliseconds aren't truncated
+	lastIncreaseTime := time.Now().Add(-150 * time.Millisecond).Truncate(time.Microsecond)
+	err := client.UpdateCache(ctx, "id1", map[string]string{
+		"tokens":                "0",
+		"tokenLastIncreaseTime": lastIncreaseTime.Format(time.RFC3339Nano),
+	}, time.Hour)
+	assert.Nil(t, err)
+	tokenNumbers, newLastIncreaseTime, _, err := client.TakeTokens(ctx, "id1", 1000, 1500*time.Microsecond, 1)
+	assert.Nil(t, err)
+	// 100 tokens added at 1.5ms, not 150 at 1ms
+	assert.InDelta(t, 99, tokenNumbers, 2)
+	assert.Equal(t, time.Duration(tokenNumbers+1)*1500*time.Microsecond, newLastIncreaseTime.Sub(lastIncreaseTime))
+
+	for i := 0; i < 10; i++ {
+		_, _, _, err = client.TakeTokens(ctx, "id2", 10, 500*time.Microsecond, 1)
+		assert.Nil(t, err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	tokenNumbers, _, _, err = client.TakeTokens(ctx, "id2", 10, 500*time.Microsecond, 1)
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, tokenNumbers, 8)
+}
+
+func TestTakeTokenWithScriptNoTokenLeft(t *testing.T) {
+	_, client := newTestRedisClient(t)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		tokenNumbers, _, _, err := client.TakeTokens(ctx, "id1", 2, time.Minute, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, 1-i, tokenNumbers)
+	}
+	tokenNumbers, _, _, err := client.TakeTokens(ctx, "id1", 2, time.Minute, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, -1, tokenNumbers)
+
+	// rejected take doesn't update the bucket
+	currentCache, err := client.GetCache(ctx, "id1")
+	assert.Nil(t, err)
+	assert.Equal(t, "0", currentCache["tokens"])
+}
+
+func TestTakeTokenWithScriptWrongData(t *testing.T) {
+	_, client := newTestRedisClient(t)
+	ctx := context.Background()
+
+	err := client.UpdateCache(ctx, "id1", map[string]string{
+		"tokens":                "5",
+		"tokenLastIncreaseTime": "wrong time format",
+	}, time.Hour)
+	assert.Nil(t, err)
+
+	tokenNumbers, _, _, err := client.TakeTokens(ctx, "id1", 10, time.Minute, 1)
+	assert.NotNil(t, err)
+	assert.Equal(t, 9, tokenNumbers)
+}
+
+func TestTakeTokenWithScriptConcurrent(t *testing.T) {
+	_, client := newTestRedisClient(t)
+	ctx := context.Background()
+
+	var allowed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tokenNumbers, _, _, err := client.TakeTokens(ctx, "id1", 10, time.Minute, 1)
+			assert.Nil(t, err)
+			if tokenNumbers >= 0 {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(10), allowed)
+}
+
+func TestTakeTokensWithScript(t *testing.T) {
+	_, client := newTestRedisClient(t)
+	ctx := context.Background()
+
+	tokenNumbers, _, _, err := client.TakeTokens(ctx, "id1", 10, time.Minute, 7)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, tokenNumbers)
+
+	// not enough tokens, nothing is taken
+	tokenNumbers, _, _, err = client.TakeTokens(ctx, "id1", 10, time.Minute, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, -2, tokenNumbers)
+
+	tokenNumbers, _, _, err = client.TakeTokens(ctx, "id1", 10, time.Minute, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, tokenNumbers)
+}
+
+func TestReserveTokensWithScript(t *testing.T) {
+	_, client := newTestRedisClient(t)
+	ctx := context.Background()
+
+	tokenNumbers, _, _, err := client.TakeTokens(ctx, "id1", 2, 30*time.Second, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, tokenNumbers)
+
+	// the next token is added in 30s
+	tokenNumbers, _, _, err = client.ReserveTokens(ctx, "id1", 2, 30*time.Second, 1, 10*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, -1, tokenNumbers)
+
+	tokenNumbers, readyAt, _, err := client.ReserveTokens(ctx, "id1", 2, 30*time.Second, 1, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, tokenNumbers)
+	assert.InDelta(t, float64(30*time.Second), float64(time.Until(readyAt)), float64(time.Second))
+
+	// reserved token is not available to others
+	tokenNumbers, _, _, err = client.TakeTokens(ctx, "id1", 2, 30*time.Second, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, -1, tokenNumbers)
+}
+
+func TestRefundTokensWithScript(t *testing.T) {
+	_, client := newTestRedisClient(t)
+	ctx := context.Background()
+
+	tokenNumbers, _, _, err := client.TakeTokens(ctx, "id1", 10, 30*time.Second, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, tokenNumbers)
+
+	tokenNumbers, _, _, err = client.RefundTokens(ctx, "id1", 10, 30*time.Second, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 8, tokenNumbers)
+
+	// capped at burst size
+	tokenNumbers, _, expireTime, err := client.RefundTokens(ctx, "id1", 10, 30*time.Second, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, tokenNumbers)
+	assert.True(t, expireTime <= 0)
+
+	_, _, _, err = client.RefundTokens(ctx, "id1", 10, 30*time.Second, 0)
+	assert.NotNil(t, err)
+
+	// tokens reserved ahead are given back first
+	_, _, _, err = client.ReserveTokens(ctx, "id2", 1, 30*time.Second, 1, time.Minute)
+	assert.Nil(t, err)
+	_, lastIncreaseTime, _, err := client.ReserveTokens(ctx, "id2", 1, 30*time.Second, 1, time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, lastIncreaseTime.After(time.Now()))
+	tokenNumbers, newLastIncreaseTime, _, err := client.RefundTokens(ctx, "id2", 1, 30*time.Second, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, tokenNumbers)
+	assert.Equal(t, lastIncreaseTime.Add(-30*time.Second), newLastIncreaseTime)
+}
@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer returns the tracer of the global tracer provider, which is a no-op until otel.SetTracerProvider is called
+// it's looked up on every call, a tracer kept in a variable stays bound to the first provider set
+func tracer() trace.Tracer {
+	return otel.Tracer("github.com/Azure/rate-limiter/pkg/cache")
+}
+
+// startSpan starts the span of a cache client operation, the span must be ended by endSpan
+func startSpan(ctx context.Context, name string, backend string) (context.Context, trace.Span) {
+	return tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attribute.String("ratelimiter.backend", backend)))
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceCommand runs a redis command in a child span, so slow commands can be told apart
+func traceCommand(ctx context.Context, command string, run func(ctx context.Context) error) error {
+	ctx, span := tracer().Start(ctx, "redis "+command, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "redis"), attribute.String("db.operation", command)))
+	err := run(ctx)
+	endSpan(span, err)
+	return err
+}
@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestGetCacheSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(provider) })
+	server, client := newTestRedisClient(t)
+
+	_, err := client.GetCache(context.Background(), "id1")
+	assert.Nil(t, err)
+	spans := recorder.Ended()
+	assert.Len(t, spans, 3)
+	assert.Equal(t, "redis PING", spans[0].Name())
+	assert.Equal(t, "redis HGETALL", spans[1].Name())
+	assert.Equal(t, "RedisClient.GetCache", spans[2].Name())
+	assert.Equal(t, spans[2].SpanContext().SpanID(), spans[1].Parent().SpanID())
+
+	server.Close()
+	_, err = client.GetCache(context.Background(), "id1")
+	assert.NotNil(t, err)
+	spans = recorder.Ended()
+	assert.Equal(t, "RedisClient.GetCache", spans[len(spans)-1].Name())
+	assert.Len(t, spans[len(spans)-1].Events(), 1)
+}
@@ -0,0 +1,186 @@
+package envoyrls
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// RateLimitConfig is a limit in envoy ratelimit service format, e.g. 10 requests per minute
+// it's converted to a token bucket with burst size requests_per_unit and a token dropped every unit/requests_per_unit
+// a token must be dropped at most every 1ms, so a limit can't be more than 1000 requests per second
+type RateLimitConfig struct {
+	Unit            string `yaml:"unit"`
+	RequestsPerUnit int    `yaml:"requests_per_unit"`
+}
+
+// DescriptorConfig matches a descriptor entry by key and value, empty value matches any value and every value gets its own bucket
+type DescriptorConfig struct {
+	Key         string             `yaml:"key"`
+	Value       string             `yaml:"value"`
+	RateLimit   *RateLimitConfig   `yaml:"rate_limit"`
+	Descriptors []DescriptorConfig `yaml:"descriptors"`
+}
+
+type DomainConfig struct {
+	Domain      string             `yaml:"domain"`
+	Descriptors []DescriptorConfig `yaml:"descriptors"`
+}
+
+// Config holds descriptor configs of all domains
+type Config struct {
+	domains map[string]DomainConfig
+}
+
+type limit struct {
+	name            string
+	burstSize       int
+	rate            time.Duration
+	unit            rlsv3.RateLimitResponse_RateLimit_Unit
+	requestsPerUnit uint32
+}
+
+// minRate is the shortest time between two tokens of a limit
+const minRate = time.Millisecond
+
+var units = map[string]rlsv3.RateLimitResponse_RateLimit_Unit{
+	"second": rlsv3.RateLimitResponse_RateLimit_SECOND,
+	"minute": rlsv3.RateLimitResponse_RateLimit_MINUTE,
+	"hour":   rlsv3.RateLimitResponse_RateLimit_HOUR,
+	"day":    rlsv3.RateLimitResponse_RateLimit_DAY,
+}
+
+var unitDurations = map[rlsv3.RateLimitResponse_RateLimit_Unit]time.Duration{
+	rlsv3.RateLimitResponse_RateLimit_SECOND: time.Second,
+	rlsv3.RateLimitResponse_RateLimit_MINUTE: time.Minute,
+	rlsv3.RateLimitResponse_RateLimit_HOUR:   time.Hour,
+	rlsv3.RateLimitResponse_RateLimit_DAY:    24 * time.Hour,
+}
+
+// LoadConfig loads one domain config from every file
+func LoadConfig(paths ...string) (*Config, error) {
+	domains := make([]DomainConfig, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		domain, err := ParseDomainConfig(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config %s: %w", path, err)
+		}
+		domains = append(domains, domain)
+	}
+	return NewConfig(domains...)
+}
+
+func ParseDomainConfig(data []byte) (DomainConfig, error) {
+	var domain DomainConfig
+	if err := yaml.Unmarshal(data, &domain); err != nil {
+		return DomainConfig{}, err
+	}
+	return domain, nil
+}
+
+func NewConfig(domains ...DomainConfig) (*Config, error) {
+	config := &Config{domains: make(map[string]DomainConfig, len(domains))}
+	for _, domain := range domains {
+		if domain.Domain == "" {
+			return nil, errors.New("domain must not be empty")
+		}
+		if _, found := config.domains[domain.Domain]; found {
+			return nil, fmt.Errorf("duplicate domain %s", domain.Domain)
+		}
+		if err := validateDescriptors(domain.Descriptors, domain.Domain); err != nil {
+			return nil, err
+		}
+		config.domains[domain.Domain] = domain
+	}
+	return config, nil
+}
+
+func validateDescriptors(descriptors []DescriptorConfig, path string) error {
+	seen := make(map[string]bool, len(descriptors))
+	for _, descriptor := range descriptors {
+		if descriptor.Key == "" {
+			return fmt.Errorf("descriptor key must not be empty in %s", path)
+		}
+		descriptorPath := path + "." + descriptor.Key
+		if descriptor.Value != "" {
+			descriptorPath += "_" + descriptor.Value
+		}
+		if seen[descriptorPath] {
+			return fmt.Errorf("duplicate descriptor %s", descriptorPath)
+		}
+		seen[descriptorPath] = true
+		if descriptor.RateLimit != nil {
+			unit, found := units[strings.ToLower(descriptor.RateLimit.Unit)]
+			if !found {
+				return fmt.Errorf("invalid unit %q in %s, must be one of second, minute, hour, day", descriptor.RateLimit.Unit, descriptorPath)
+			}
+			if descriptor.RateLimit.RequestsPerUnit <= 0 {
+				return fmt.Errorf("requests_per_unit must be greater than 0 in %s", descriptorPath)
+			}
+			if unitDurations[unit]/time.Duration(descriptor.RateLimit.RequestsPerUnit) < minRate {
+				return fmt.Errorf("requests_per_unit %d per %s is more than 1000 per second in %s", descriptor.RateLimit.RequestsPerUnit, descriptor.RateLimit.Unit, descriptorPath)
+			}
+		}
+		if err := validateDescriptors(descriptor.Descriptors, descriptorPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getLimit returns the limit of the descriptor config matching all entries, nil if no limit
+// an exact value match is preferred over the key only match
+func (c *Config) getLimit(domain string, entries []descriptorEntry) *limit {
+	domainConfig, found := c.domains[domain]
+	if !found || len(entries) == 0 {
+		return nil
+	}
+	descriptors := domainConfig.Descriptors
+	var matched *DescriptorConfig
+	for _, entry := range entries {
+		matched = nil
+		for i := range descriptors {
+			if descriptors[i].Key != entry.key {
+				continue
+			}
+			if descriptors[i].Value == entry.value {
+				matched = &descriptors[i]
+				break
+			}
+			if descriptors[i].Value == "" {
+				matched = &descriptors[i]
+			}
+		}
+		if matched == nil {
+			return nil
+		}
+		descriptors = matched.Descriptors
+	}
+	if matched.RateLimit == nil {
+		return nil
+	}
+	return newLimit(descriptorKey(domain, entries), matched.RateLimit.RequestsPerUnit, units[strings.ToLower(matched.RateLimit.Unit)])
+}
+
+func newLimit(name string, requestsPerUnit int, unit rlsv3.RateLimitResponse_RateLimit_Unit) *limit {
+	unitDuration, found := unitDurations[unit]
+	if !found || requestsPerUnit <= 0 {
+		return nil
+	}
+	return &limit{
+		name:            name,
+		burstSize:       requestsPerUnit,
+		rate:            unitDuration / time.Duration(requestsPerUnit),
+		unit:            unit,
+		requestsPerUnit: uint32(requestsPerUnit),
+	}
+}
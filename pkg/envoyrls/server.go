@@ -0,0 +1,109 @@
+package envoyrls
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/Azure/rate-limiter/ratelimiter"
+)
+
+// Server implements envoy.service.ratelimit.v3.RateLimitService with the rate limiter
+// every descriptor is limited by its own bucket, the request is over limit when any descriptor is over limit
+type Server struct {
+	rlsv3.UnimplementedRateLimitServiceServer
+	rateLimiter ratelimiter.RateLimiter
+	config      *Config
+}
+
+type descriptorEntry struct {
+	key   string
+	value string
+}
+
+func NewServer(rateLimiter ratelimiter.RateLimiter, config *Config) *Server {
+	return &Server{
+		rateLimiter: rateLimiter,
+		config:      config,
+	}
+}
+
+func (s *Server) ShouldRateLimit(ctx context.Context, req *rlsv3.RateLimitRequest) (*rlsv3.RateLimitResponse, error) {
+	if req.GetDomain() == "" {
+		return nil, status.Error(codes.InvalidArgument, "domain must not be empty")
+	}
+	if len(req.GetDescriptors()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "descriptors must not be empty")
+	}
+	cost := int(req.GetHitsAddend())
+	if cost == 0 {
+		cost = 1
+	}
+	response := &rlsv3.RateLimitResponse{
+		OverallCode: rlsv3.RateLimitResponse_OK,
+		Statuses:    make([]*rlsv3.RateLimitResponse_DescriptorStatus, 0, len(req.GetDescriptors())),
+	}
+	for _, descriptor := range req.GetDescriptors() {
+		descriptorStatus := s.getDescriptorStatus(ctx, req.GetDomain(), descriptor, cost)
+		if descriptorStatus.Code == rlsv3.RateLimitResponse_OVER_LIMIT {
+			response.OverallCode = rlsv3.RateLimitResponse_OVER_LIMIT
+		}
+		response.Statuses = append(response.Statuses, descriptorStatus)
+	}
+	return response, nil
+}
+
+func (s *Server) getDescriptorStatus(ctx context.Context, domain string, descriptor *ratelimitv3.RateLimitDescriptor, cost int) *rlsv3.RateLimitResponse_DescriptorStatus {
+	entries := make([]descriptorEntry, 0, len(descriptor.GetEntries()))
+	for _, entry := range descriptor.GetEntries() {
+		entries = append(entries, descriptorEntry{key: entry.GetKey(), value: entry.GetValue()})
+	}
+	l := s.config.getLimit(domain, entries)
+	// limit in the request overrides the configured one
+	if override := descriptor.GetLimit(); override != nil {
+		if overrideLimit := newLimit(descriptorKey(domain, entries), int(override.GetRequestsPerUnit()), rlsv3.RateLimitResponse_RateLimit_Unit(override.GetUnit())); overrideLimit != nil {
+			l = overrideLimit
+		}
+	}
+	if l == nil {
+		return &rlsv3.RateLimitResponse_DescriptorStatus{Code: rlsv3.RateLimitResponse_OK}
+	}
+
+	decision, err := s.rateLimiter.GetDecisionWithCost(ctx, l.name, l.burstSize, l.rate, cost)
+	if err != nil {
+		log.Printf("failed to get decision for descriptor %s: %s", l.name, err)
+	}
+	descriptorStatus := &rlsv3.RateLimitResponse_DescriptorStatus{
+		Code: rlsv3.RateLimitResponse_OK,
+		CurrentLimit: &rlsv3.RateLimitResponse_RateLimit{
+			Name:            l.name,
+			RequestsPerUnit: l.requestsPerUnit,
+			Unit:            l.unit,
+		},
+		LimitRemaining: uint32(max(decision.Remaining, 0)),
+	}
+	if !decision.Allowed {
+		descriptorStatus.Code = rlsv3.RateLimitResponse_OVER_LIMIT
+		descriptorStatus.DurationUntilReset = durationpb.New(decision.RetryAfter)
+	} else if !decision.ResetAt.IsZero() {
+		descriptorStatus.DurationUntilReset = durationpb.New(max(time.Until(decision.ResetAt), 0))
+	}
+	return descriptorStatus
+}
+
+// descriptorKey is the bucket key of a descriptor, e.g. "domain_tenant_id1_method_create"
+func descriptorKey(domain string, entries []descriptorEntry) string {
+	parts := make([]string, 0, 2*len(entries)+1)
+	parts = append(parts, domain)
+	for _, entry := range entries {
+		parts = append(parts, entry.key, entry.value)
+	}
+	return strings.Join(parts, "_")
+}
@@ -0,0 +1,142 @@
+package envoyrls
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Azure/rate-limiter/pkg/cache"
+	"github.com/Azure/rate-limiter/ratelimiter"
+)
+
+const testConfig = `
+domain: cluster-service
+descriptors:
+  - key: billingAccount
+    rate_limit:
+      unit: minute
+      requests_per_unit: 2
+    descriptors:
+      - key: method
+        value: list
+        rate_limit:
+          unit: second
+          requests_per_unit: 100
+  - key: billingAccount
+    value: internal
+`
+
+func newTestServer(t *testing.T) *Server {
+	domain, err := ParseDomainConfig([]byte(testConfig))
+	assert.Nil(t, err)
+	config, err := NewConfig(domain)
+	assert.Nil(t, err)
+	memClient := cache.NewMemCacheClient(time.Minute, time.Minute)
+	// memcache is used as remote cache to keep decisions local to the test
+	return NewServer(ratelimiter.NewTokenBucketRateLimiter(memClient, cache.NewMemCacheClient(time.Minute, time.Minute)), config)
+}
+
+func newRequest(hitsAddend uint32, descriptors ...[]string) *rlsv3.RateLimitRequest {
+	req := &rlsv3.RateLimitRequest{Domain: "cluster-service", HitsAddend: hitsAddend}
+	for _, pairs := range descriptors {
+		descriptor := &ratelimitv3.RateLimitDescriptor{}
+		for i := 0; i < len(pairs); i += 2 {
+			descriptor.Entries = append(descriptor.Entries, &ratelimitv3.RateLimitDescriptor_Entry{Key: pairs[i], Value: pairs[i+1]})
+		}
+		req.Descriptors = append(req.Descriptors, descriptor)
+	}
+	return req
+}
+
+func TestShouldRateLimit(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+
+	resp, err := server.ShouldRateLimit(ctx, newRequest(0, []string{"billingAccount", "id1"}, []string{"billingAccount", "id1", "method", "list"}))
+	assert.Nil(t, err)
+	assert.Equal(t, rlsv3.RateLimitResponse_OK, resp.OverallCode)
+	assert.Len(t, resp.Statuses, 2)
+	assert.Equal(t, uint32(2), resp.Statuses[0].CurrentLimit.RequestsPerUnit)
+	assert.Equal(t, rlsv3.RateLimitResponse_RateLimit_MINUTE, resp.Statuses[0].CurrentLimit.Unit)
+	assert.Equal(t, "cluster-service_billingAccount_id1", resp.Statuses[0].CurrentLimit.Name)
+	assert.Equal(t, uint32(1), resp.Statuses[0].LimitRemaining)
+	assert.Equal(t, uint32(99), resp.Statuses[1].LimitRemaining)
+
+	resp, err = server.ShouldRateLimit(ctx, newRequest(2, []string{"billingAccount", "id1"}, []string{"billingAccount", "id1", "method", "list"}))
+	assert.Nil(t, err)
+	assert.Equal(t, rlsv3.RateLimitResponse_OVER_LIMIT, resp.OverallCode)
+	assert.Equal(t, rlsv3.RateLimitResponse_OVER_LIMIT, resp.Statuses[0].Code)
+	assert.True(t, resp.Statuses[0].DurationUntilReset.AsDuration() > 0)
+	assert.Equal(t, rlsv3.RateLimitResponse_OK, resp.Statuses[1].Code)
+
+	// other billing accounts have their own bucket
+	resp, err = server.ShouldRateLimit(ctx, newRequest(0, []string{"billingAccount", "id2"}))
+	assert.Nil(t, err)
+	assert.Equal(t, rlsv3.RateLimitResponse_OK, resp.OverallCode)
+}
+
+func TestShouldRateLimitNoLimit(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		resp, err := server.ShouldRateLimit(ctx, newRequest(0, []string{"billingAccount", "internal"}, []string{"unknown", "value"}))
+		assert.Nil(t, err)
+		assert.Equal(t, rlsv3.RateLimitResponse_OK, resp.OverallCode)
+		assert.Nil(t, resp.Statuses[0].CurrentLimit)
+		assert.Nil(t, resp.Statuses[1].CurrentLimit)
+	}
+
+	_, err := server.ShouldRateLimit(ctx, &rlsv3.RateLimitRequest{})
+	assert.NotNil(t, err)
+}
+
+func TestShouldRateLimitOverride(t *testing.T) {
+	server := newTestServer(t)
+	req := newRequest(0, []string{"billingAccount", "id1"})
+	req.Descriptors[0].Limit = &ratelimitv3.RateLimitDescriptor_RateLimitOverride{
+		RequestsPerUnit: 5,
+		Unit:            3, // hour
+	}
+
+	resp, err := server.ShouldRateLimit(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(5), resp.Statuses[0].CurrentLimit.RequestsPerUnit)
+	assert.Equal(t, rlsv3.RateLimitResponse_RateLimit_HOUR, resp.Statuses[0].CurrentLimit.Unit)
+	assert.Equal(t, uint32(4), resp.Statuses[0].LimitRemaining)
+}
+
+func TestNewConfigValidation(t *testing.T) {
+	for _, config := range []string{
+		`descriptors: [{key: a}]`,
+		`{domain: d, descriptors: [{key: ""}]}`,
+		`{domain: d, descriptors: [{key: a, rate_limit: {unit: week, requests_per_unit: 1}}]}`,
+		`{domain: d, descriptors: [{key: a, rate_limit: {unit: minute, requests_per_unit: 0}}]}`,
+		`{domain: d, descriptors: [{key: a, value: b}, {key: a, value: b}]}`,
+		`{domain: d, descriptors: [{key: a, descriptors: [{key: b, rate_limit: {unit: year, requests_per_unit: 1}}]}]}`,
+		// a token every 200us
+		`{domain: d, descriptors: [{key: a, rate_limit: {unit: second, requests_per_unit: 5000}}]}`,
+		`{domain: d, descriptors: [{key: a, rate_limit: {unit: minute, requests_per_unit: 60001}}]}`,
+	} {
+		domain, err := ParseDomainConfig([]byte(config))
+		assert.Nil(t, err)
+		_, err = NewConfig(domain)
+		assert.NotNil(t, err, config)
+	}
+
+	domain, err := ParseDomainConfig([]byte(testConfig))
+	assert.Nil(t, err)
+	_, err = NewConfig(domain, domain)
+	assert.NotNil(t, err)
+
+	// 1000 requests per second is a token every 1ms
+	domain, err = ParseDomainConfig([]byte(`{domain: d, descriptors: [{key: a, rate_limit: {unit: second, requests_per_unit: 1000}}]}`))
+	assert.Nil(t, err)
+	config, err := NewConfig(domain)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Millisecond, config.getLimit("d", []descriptorEntry{{key: "a", value: "x"}}).rate)
+}
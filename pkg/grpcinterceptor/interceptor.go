@@ -0,0 +1,194 @@
+package grpcinterceptor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Azure/rate-limiter/ratelimiter"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const compoundKeySep = ":"
+
+// KeyFunc gets the rate limit key of a call, fullMethod is in the format of /package.service/method
+type KeyFunc func(ctx context.Context, fullMethod string) (string, error)
+
+// MethodKey uses the full method name as key
+func MethodKey() KeyFunc {
+	return func(ctx context.Context, fullMethod string) (string, error) {
+		return fullMethod, nil
+	}
+}
+
+// MetadataKey uses the first value of incoming metadata name as key
+func MetadataKey(name string) KeyFunc {
+	return func(ctx context.Context, fullMethod string) (string, error) {
+		values := metadata.ValueFromIncomingContext(ctx, name)
+		if len(values) == 0 || values[0] == "" {
+			return "", fmt.Errorf("can't find metadata %s in request", name)
+		}
+		return values[0], nil
+	}
+}
+
+// CompoundKey joins keys of all key funcs, e.g. MethodKey and MetadataKey limit each caller per method
+func CompoundKey(keyFuncs ...KeyFunc) KeyFunc {
+	return func(ctx context.Context, fullMethod string) (string, error) {
+		keys := make([]string, 0, len(keyFuncs))
+		for _, keyFunc := range keyFuncs {
+			key, err := keyFunc(ctx, fullMethod)
+			if err != nil {
+				return "", err
+			}
+			keys = append(keys, key)
+		}
+		return strings.Join(keys, compoundKeySep), nil
+	}
+}
+
+type decisionContextKey struct{}
+
+// DecisionFromContext returns the decision made for the call by the interceptor
+func DecisionFromContext(ctx context.Context) (ratelimiter.RateLimiterDecision, bool) {
+	decision, ok := ctx.Value(decisionContextKey{}).(ratelimiter.RateLimiterDecision)
+	return decision, ok
+}
+
+type Interceptor struct {
+	rateLimiter    ratelimiter.RateLimiter
+	keyFunc        KeyFunc
+	burstSize      int
+	rate           time.Duration
+	costFunc       func(ctx context.Context, fullMethod string) int
+	limitMessages  bool
+	messageKeyFunc KeyFunc
+	errorFunc      func(ctx context.Context, err error)
+}
+
+type Option func(*Interceptor)
+
+// WithCost sets the number of tokens taken by a call, default is 1
+func WithCost(costFunc func(ctx context.Context, fullMethod string) int) Option {
+	return func(i *Interceptor) {
+		i.costFunc = costFunc
+	}
+}
+
+// WithStreamMessageLimit takes a token for every message received on a stream besides the stream itself
+// messages are limited by the key from keyFunc, nil uses the same key as the stream
+func WithStreamMessageLimit(keyFunc KeyFunc) Option {
+	return func(i *Interceptor) {
+		i.limitMessages = true
+		i.messageKeyFunc = keyFunc
+	}
+}
+
+// WithErrorHandler is called when rate limiter returns an error, the decision is still respected, default is logging the error
+func WithErrorHandler(errorFunc func(ctx context.Context, err error)) Option {
+	return func(i *Interceptor) {
+		i.errorFunc = errorFunc
+	}
+}
+
+func NewInterceptor(rateLimiter ratelimiter.RateLimiter, keyFunc KeyFunc, burstSize int, rate time.Duration, opts ...Option) *Interceptor {
+	i := &Interceptor{
+		rateLimiter: rateLimiter,
+		keyFunc:     keyFunc,
+		burstSize:   burstSize,
+		rate:        rate,
+		costFunc:    func(ctx context.Context, fullMethod string) int { return 1 },
+		errorFunc: func(ctx context.Context, err error) {
+			log.Printf("failed to get rate limiter decision: %s", err)
+		},
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	if i.limitMessages && i.messageKeyFunc == nil {
+		i.messageKeyFunc = i.keyFunc
+	}
+	return i
+}
+
+// UnaryServerInterceptor rejects calls with codes.ResourceExhausted and a RetryInfo detail when rate limited
+func (i *Interceptor) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		decision, err := i.getDecision(ctx, i.keyFunc, info.FullMethod, i.costFunc(ctx, info.FullMethod))
+		if err != nil {
+			return nil, err
+		}
+		return handler(context.WithValue(ctx, decisionContextKey{}, decision), req)
+	}
+}
+
+// StreamServerInterceptor rejects streams when rate limited, and individual messages when WithStreamMessageLimit is set
+func (i *Interceptor) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		decision, err := i.getDecision(ctx, i.keyFunc, info.FullMethod, i.costFunc(ctx, info.FullMethod))
+		if err != nil {
+			return err
+		}
+		return handler(srv, &rateLimitedServerStream{
+			ServerStream: ss,
+			ctx:          context.WithValue(ctx, decisionContextKey{}, decision),
+			interceptor:  i,
+			fullMethod:   info.FullMethod,
+		})
+	}
+}
+
+// getDecision returns a status error when the call is rejected
+func (i *Interceptor) getDecision(ctx context.Context, keyFunc KeyFunc, fullMethod string, cost int) (ratelimiter.RateLimiterDecision, error) {
+	key, err := keyFunc(ctx, fullMethod)
+	if err != nil {
+		return ratelimiter.RateLimiterDecision{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	decision, err := i.rateLimiter.GetDecisionWithCost(ctx, key, i.burstSize, i.rate, cost)
+	if err != nil {
+		i.errorFunc(ctx, err)
+	}
+	if !decision.Allowed {
+		return decision, resourceExhaustedError(decision.RetryAfter)
+	}
+	return decision, nil
+}
+
+func resourceExhaustedError(retryAfter time.Duration) error {
+	st := status.New(codes.ResourceExhausted, fmt.Sprintf("too many requests, retry after %s", retryAfter))
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+type rateLimitedServerStream struct {
+	grpc.ServerStream
+	ctx         context.Context
+	interceptor *Interceptor
+	fullMethod  string
+}
+
+func (s *rateLimitedServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *rateLimitedServerStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if !s.interceptor.limitMessages {
+		return nil
+	}
+	_, err := s.interceptor.getDecision(s.ctx, s.interceptor.messageKeyFunc, s.fullMethod, 1)
+	return err
+}
@@ -0,0 +1,122 @@
+package grpcinterceptor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/rate-limiter/ratelimiter"
+	"github.com/Azure/rate-limiter/ratelimiter/mock_ratelimiter"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const testMethod = "/cluster.v1.ClusterService/CreateCluster"
+
+func incomingContext(pairs ...string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(pairs...))
+}
+
+func TestKeyFuncs(t *testing.T) {
+	ctx := incomingContext("x-tenant", "tenant1")
+
+	key, err := CompoundKey(MethodKey(), MetadataKey("x-tenant"))(ctx, testMethod)
+	assert.Nil(t, err)
+	assert.Equal(t, testMethod+":tenant1", key)
+
+	_, err = MetadataKey("x-missing")(ctx, testMethod)
+	assert.NotNil(t, err)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rateLimiter := mock_ratelimiter.NewMockRateLimiter(ctrl)
+	allowed := ratelimiter.RateLimiterDecision{Allowed: true, Limit: 10, Remaining: 9}
+	rejected := ratelimiter.RateLimiterDecision{Allowed: false, RetryAfter: 30 * time.Second}
+	gomock.InOrder(
+		rateLimiter.EXPECT().GetDecisionWithCost(gomock.Any(), "tenant1", 10, time.Minute, 1).Return(allowed, nil),
+		rateLimiter.EXPECT().GetDecisionWithCost(gomock.Any(), "tenant1", 10, time.Minute, 1).Return(rejected, nil),
+	)
+
+	interceptor := NewInterceptor(rateLimiter, MetadataKey("x-tenant"), 10, time.Minute).UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: testMethod}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		decision, ok := DecisionFromContext(ctx)
+		assert.True(t, ok)
+		assert.Equal(t, allowed, decision)
+		return "created", nil
+	}
+
+	resp, err := interceptor(incomingContext("x-tenant", "tenant1"), nil, info, handler)
+	assert.Nil(t, err)
+	assert.Equal(t, "created", resp)
+
+	_, err = interceptor(incomingContext("x-tenant", "tenant1"), nil, info, handler)
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	assert.Len(t, st.Details(), 1)
+	retryInfo, ok := st.Details()[0].(*errdetails.RetryInfo)
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, retryInfo.RetryDelay.AsDuration())
+
+	// no key
+	_, err = interceptor(context.Background(), nil, info, handler)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	received int
+}
+
+func (s *fakeServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeServerStream) RecvMsg(m interface{}) error {
+	s.received++
+	return nil
+}
+
+func TestStreamServerInterceptorMessageLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rateLimiter := mock_ratelimiter.NewMockRateLimiter(ctrl)
+	allowed := ratelimiter.RateLimiterDecision{Allowed: true}
+	gomock.InOrder(
+		// stream
+		rateLimiter.EXPECT().GetDecisionWithCost(gomock.Any(), testMethod, 10, time.Minute, 1).Return(allowed, nil),
+		// messages
+		rateLimiter.EXPECT().GetDecisionWithCost(gomock.Any(), "tenant1", 10, time.Minute, 1).Return(allowed, nil),
+		rateLimiter.EXPECT().GetDecisionWithCost(gomock.Any(), "tenant1", 10, time.Minute, 1).Return(ratelimiter.RateLimiterDecision{RetryAfter: time.Second}, nil),
+	)
+
+	interceptor := NewInterceptor(rateLimiter, MethodKey(), 10, time.Minute, WithStreamMessageLimit(MetadataKey("x-tenant"))).StreamServerInterceptor()
+	stream := &fakeServerStream{ctx: incomingContext("x-tenant", "tenant1")}
+	err := interceptor(nil, stream, &grpc.StreamServerInfo{FullMethod: testMethod}, func(srv interface{}, ss grpc.ServerStream) error {
+		_, ok := DecisionFromContext(ss.Context())
+		assert.True(t, ok)
+		assert.Nil(t, ss.RecvMsg(nil))
+		return ss.RecvMsg(nil)
+	})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, 2, stream.received)
+}
+
+func TestStreamServerInterceptorRejected(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rateLimiter := mock_ratelimiter.NewMockRateLimiter(ctrl)
+	rateLimiter.EXPECT().GetDecisionWithCost(gomock.Any(), testMethod, 10, time.Minute, 1).Return(ratelimiter.RateLimiterDecision{RetryAfter: time.Second}, nil)
+
+	interceptor := NewInterceptor(rateLimiter, MethodKey(), 10, time.Minute).StreamServerInterceptor()
+	err := interceptor(nil, &fakeServerStream{ctx: context.Background()}, &grpc.StreamServerInfo{FullMethod: testMethod}, func(srv interface{}, ss grpc.ServerStream) error {
+		t.Fatal("rejected stream should not be passed to handler")
+		return nil
+	})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
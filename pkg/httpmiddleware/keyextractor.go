@@ -0,0 +1,203 @@
+package httpmiddleware
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	headerForwardedFor  = "X-Forwarded-For"
+	headerAuthorization = "Authorization"
+	compoundKeySep      = ":"
+
+	// DefaultMaxBodyBytes is the largest body JSONBodyKeyExtractor reads by default
+	DefaultMaxBodyBytes = 1 << 20
+)
+
+// HeaderKeyExtractor uses the value of header name as key
+func HeaderKeyExtractor(name string) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (string, error) {
+		value := r.Header.Get(name)
+		if value == "" {
+			return "", fmt.Errorf("can't find header %s in request", name)
+		}
+		return value, nil
+	})
+}
+
+// ClientIPKeyExtractor uses client ip as key
+// when the request comes from a trusted proxy, X-Forwarded-For is walked from right to left and the first ip which is not a trusted proxy is used
+// X-Forwarded-For is ignored when no proxy is trusted, since any client can set it
+func ClientIPKeyExtractor(trustedProxyCIDRs ...string) (KeyExtractor, error) {
+	trustedProxies := make([]*net.IPNet, 0, len(trustedProxyCIDRs))
+	for _, cidr := range trustedProxyCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy cidr %s: %w", cidr, err)
+		}
+		trustedProxies = append(trustedProxies, ipNet)
+	}
+	isTrusted := func(ip net.IP) bool {
+		for _, ipNet := range trustedProxies {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return KeyExtractorFunc(func(r *http.Request) (string, error) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return "", fmt.Errorf("invalid remote address %s", r.RemoteAddr)
+		}
+		if !isTrusted(ip) {
+			return ip.String(), nil
+		}
+		forwardedFor := strings.Split(strings.Join(r.Header.Values(headerForwardedFor), ","), ",")
+		for i := len(forwardedFor) - 1; i >= 0; i-- {
+			value := strings.TrimSpace(forwardedFor[i])
+			if value == "" {
+				continue
+			}
+			forwardedIP := net.ParseIP(value)
+			if forwardedIP == nil {
+				return "", fmt.Errorf("invalid ip %s in %s", value, headerForwardedFor)
+			}
+			ip = forwardedIP
+			if !isTrusted(ip) {
+				break
+			}
+		}
+		// every hop is trusted, ip is the leftmost one
+		return ip.String(), nil
+	}), nil
+}
+
+// PathVariableKeyExtractor uses the gorilla/mux route variable name as key
+func PathVariableKeyExtractor(name string) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (string, error) {
+		value := mux.Vars(r)[name]
+		if value == "" {
+			return "", fmt.Errorf("can't find path variable %s in request", name)
+		}
+		return value, nil
+	})
+}
+
+// JWTClaimKeyExtractor uses a claim of the bearer token in Authorization header as key
+// the token signature is NOT verified, only use it behind a component which authenticates the request
+func JWTClaimKeyExtractor(claim string) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (string, error) {
+		authorization := r.Header.Get(headerAuthorization)
+		if len(authorization) < len("Bearer ") || !strings.EqualFold(authorization[:len("Bearer ")], "Bearer ") {
+			return "", errors.New("can't find bearer token in request")
+		}
+		parts := strings.Split(strings.TrimSpace(authorization[len("Bearer "):]), ".")
+		if len(parts) != 3 {
+			return "", errors.New("bearer token is not a jwt")
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+		if err != nil {
+			return "", fmt.Errorf("failed to decode jwt payload: %w", err)
+		}
+		return jsonField(payload, claim)
+	})
+}
+
+type JSONBodyOption func(*jsonBodyKeyExtractor)
+
+type jsonBodyKeyExtractor struct {
+	maxBodyBytes int64
+}
+
+// WithMaxBodyBytes sets the largest body read to find the key, default is DefaultMaxBodyBytes
+func WithMaxBodyBytes(maxBodyBytes int64) JSONBodyOption {
+	return func(e *jsonBodyKeyExtractor) {
+		e.maxBodyBytes = maxBodyBytes
+	}
+}
+
+// JSONBodyKeyExtractor uses a field of the json request body as key, nested field is separated by dot, e.g. "properties.billingAccount"
+// body is restored so it can still be read by next handlers
+// the body is read before the request is limited, a body larger than max body bytes fails with *http.MaxBytesError,
+// which the middleware responds with 413
+func JSONBodyKeyExtractor(field string, opts ...JSONBodyOption) KeyExtractor {
+	e := &jsonBodyKeyExtractor{maxBodyBytes: DefaultMaxBodyBytes}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return KeyExtractorFunc(func(r *http.Request) (string, error) {
+		if r.Body == nil {
+			return "", errors.New("request has no body")
+		}
+		payload, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, e.maxBodyBytes))
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(payload))
+		if err != nil {
+			return "", err
+		}
+		return jsonField(payload, field)
+	})
+}
+
+// CompoundKeyExtractor joins keys of all extractors, e.g. "<tenant>:<client ip>", it fails if any extractor fails
+func CompoundKeyExtractor(extractors ...KeyExtractor) KeyExtractor {
+	return KeyExtractorFunc(func(r *http.Request) (string, error) {
+		keys := make([]string, 0, len(extractors))
+		for _, extractor := range extractors {
+			key, err := extractor.ExtractKey(r)
+			if err != nil {
+				return "", err
+			}
+			keys = append(keys, key)
+		}
+		return strings.Join(keys, compoundKeySep), nil
+	})
+}
+
+// jsonField gets string, number or bool field from a json object
+func jsonField(payload []byte, field string) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	// keep large ids as they are
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return "", err
+	}
+	for _, name := range strings.Split(field, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("can't find key %s in request", field)
+		}
+		if value, ok = object[name]; !ok {
+			return "", fmt.Errorf("can't find key %s in request", field)
+		}
+	}
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return "", fmt.Errorf("key %s is empty", field)
+		}
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", fmt.Errorf("key %s is not a string, number or bool", field)
+	}
+}
@@ -0,0 +1,144 @@
+package httpmiddleware
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderKeyExtractor(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("x-ms-client-tenant-id", "tenant1")
+
+	key, err := HeaderKeyExtractor("x-ms-client-tenant-id").ExtractKey(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "tenant1", key)
+
+	_, err = HeaderKeyExtractor("x-missing").ExtractKey(r)
+	assert.NotNil(t, err)
+}
+
+func TestClientIPKeyExtractor(t *testing.T) {
+	_, err := ClientIPKeyExtractor("wrong cidr")
+	assert.NotNil(t, err)
+
+	extractor, err := ClientIPKeyExtractor("10.0.0.0/8")
+	assert.Nil(t, err)
+
+	// forwarded for is ignored when remote address is not trusted
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "20.1.1.1:1234"
+	r.Header.Set("X-Forwarded-For", "1.1.1.1")
+	key, err := extractor.ExtractKey(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "20.1.1.1", key)
+
+	// the first untrusted ip from right, client can put anything on the left
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "1.1.1.1, 2.2.2.2")
+	r.Header.Add("X-Forwarded-For", "10.0.0.2")
+	key, err = extractor.ExtractKey(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "2.2.2.2", key)
+
+	r.Header.Set("X-Forwarded-For", "10.0.0.3, 10.0.0.2")
+	key, err = extractor.ExtractKey(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.3", key)
+
+	r.Header.Set("X-Forwarded-For", "not an ip")
+	_, err = extractor.ExtractKey(r)
+	assert.NotNil(t, err)
+}
+
+func TestPathVariableKeyExtractor(t *testing.T) {
+	var key string
+	var err error
+	router := mux.NewRouter()
+	router.HandleFunc("/billingAccount/{billingAccount}", func(rw http.ResponseWriter, r *http.Request) {
+		key, err = PathVariableKeyExtractor("billingAccount").ExtractKey(r)
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/billingAccount/id1", nil))
+	assert.Nil(t, err)
+	assert.Equal(t, "id1", key)
+
+	_, err = PathVariableKeyExtractor("billingAccount").ExtractKey(httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.NotNil(t, err)
+}
+
+func TestJWTClaimKeyExtractor(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"oid":"user1","tid":"tenant1","iat":1700000000}`))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer header."+payload+".signature")
+
+	key, err := JWTClaimKeyExtractor("tid").ExtractKey(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "tenant1", key)
+
+	key, err = JWTClaimKeyExtractor("iat").ExtractKey(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "1700000000", key)
+
+	_, err = JWTClaimKeyExtractor("missing").ExtractKey(r)
+	assert.NotNil(t, err)
+
+	r.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	_, err = JWTClaimKeyExtractor("tid").ExtractKey(r)
+	assert.NotNil(t, err)
+}
+
+func TestJSONBodyKeyExtractor(t *testing.T) {
+	body := `{"billingAccount":"id1","properties":{"subscription":12345678901234567890,"enabled":true}}`
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+
+	key, err := JSONBodyKeyExtractor("billingAccount").ExtractKey(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "id1", key)
+
+	// body is restored for the next extractor and handler
+	key, err = JSONBodyKeyExtractor("properties.subscription").ExtractKey(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "12345678901234567890", key)
+
+	key, err = JSONBodyKeyExtractor("properties.enabled").ExtractKey(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "true", key)
+
+	_, err = JSONBodyKeyExtractor("properties").ExtractKey(r)
+	assert.NotNil(t, err)
+	_, err = JSONBodyKeyExtractor("billingAccount.id").ExtractKey(r)
+	assert.NotNil(t, err)
+
+	payload, err := io.ReadAll(r.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, body, string(payload))
+
+	_, err = JSONBodyKeyExtractor("billingAccount").ExtractKey(httptest.NewRequest(http.MethodPost, "/", strings.NewReader("not json")))
+	assert.NotNil(t, err)
+
+	// large body isn't buffered
+	_, err = JSONBodyKeyExtractor("billingAccount", WithMaxBodyBytes(10)).ExtractKey(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	var maxBytesErr *http.MaxBytesError
+	assert.ErrorAs(t, err, &maxBytesErr)
+	key, err = JSONBodyKeyExtractor("billingAccount", WithMaxBodyBytes(int64(len(body)))).ExtractKey(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	assert.Nil(t, err)
+	assert.Equal(t, "id1", key)
+}
+
+func TestCompoundKeyExtractor(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"billingAccount":"id1"}`))
+	r.Header.Set("x-tenant", "tenant1")
+
+	key, err := CompoundKeyExtractor(HeaderKeyExtractor("x-tenant"), JSONBodyKeyExtractor("billingAccount")).ExtractKey(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "tenant1:id1", key)
+
+	_, err = CompoundKeyExtractor(HeaderKeyExtractor("x-tenant"), HeaderKeyExtractor("x-missing")).ExtractKey(r)
+	assert.NotNil(t, err)
+}
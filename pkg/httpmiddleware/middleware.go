@@ -0,0 +1,225 @@
+package httpmiddleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Azure/rate-limiter/ratelimiter"
+)
+
+const (
+	// headers defined by https://datatracker.ietf.org/doc/draft-ietf-httpapi-ratelimit-headers/
+	HeaderRateLimit       = "RateLimit"
+	HeaderRateLimitPolicy = "RateLimit-Policy"
+	HeaderRetryAfter      = "Retry-After"
+)
+
+// KeyExtractor gets the rate limit key of a request
+type KeyExtractor interface {
+	ExtractKey(r *http.Request) (string, error)
+}
+
+// KeyExtractorFunc adapts a function to KeyExtractor
+type KeyExtractorFunc func(r *http.Request) (string, error)
+
+func (f KeyExtractorFunc) ExtractKey(r *http.Request) (string, error) {
+	return f(r)
+}
+
+type decisionContextKey struct{}
+
+// DecisionFromContext returns the decision made for the request by the middleware
+func DecisionFromContext(ctx context.Context) (ratelimiter.RateLimiterDecision, bool) {
+	decision, ok := ctx.Value(decisionContextKey{}).(ratelimiter.RateLimiterDecision)
+	return decision, ok
+}
+
+type Middleware struct {
+	rateLimiter  ratelimiter.RateLimiter
+	keyExtractor KeyExtractor
+	burstSize    int
+	rate         time.Duration
+	costFunc     func(r *http.Request) int
+	keyErrorFunc func(rw http.ResponseWriter, r *http.Request, err error)
+	errorFunc    func(r *http.Request, err error)
+	refundPolicy RefundPolicy
+}
+
+// RefundPolicy decides whether tokens taken by a request are refunded by the status code of its response
+type RefundPolicy func(statusCode int) bool
+
+// RefundStatusCodes refunds tokens of responses with any of the status codes
+func RefundStatusCodes(statusCodes ...int) RefundPolicy {
+	return func(statusCode int) bool {
+		for _, code := range statusCodes {
+			if code == statusCode {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// RefundClientErrors refunds tokens of 4xx responses
+func RefundClientErrors() RefundPolicy {
+	return func(statusCode int) bool {
+		return statusCode >= 400 && statusCode < 500
+	}
+}
+
+type Option func(*Middleware)
+
+// WithCost sets the number of tokens taken by a request, default is 1
+func WithCost(costFunc func(r *http.Request) int) Option {
+	return func(m *Middleware) {
+		m.costFunc = costFunc
+	}
+}
+
+// WithKeyErrorHandler handles requests whose key can't be extracted, default is responding 400, or 413 when the body is too large
+func WithKeyErrorHandler(keyErrorFunc func(rw http.ResponseWriter, r *http.Request, err error)) Option {
+	return func(m *Middleware) {
+		m.keyErrorFunc = keyErrorFunc
+	}
+}
+
+// WithErrorHandler is called when rate limiter returns an error, the decision is still respected, default is logging the error
+func WithErrorHandler(errorFunc func(r *http.Request, err error)) Option {
+	return func(m *Middleware) {
+		m.errorFunc = errorFunc
+	}
+}
+
+// WithRefundPolicy gives tokens back when the response status code matches policy, so e.g. invalid requests don't count against the caller
+func WithRefundPolicy(policy RefundPolicy) Option {
+	return func(m *Middleware) {
+		m.refundPolicy = policy
+	}
+}
+
+func NewMiddleware(rateLimiter ratelimiter.RateLimiter, keyExtractor KeyExtractor, burstSize int, rate time.Duration, opts ...Option) *Middleware {
+	m := &Middleware{
+		rateLimiter:  rateLimiter,
+		keyExtractor: keyExtractor,
+		burstSize:    burstSize,
+		rate:         rate,
+		costFunc:     func(r *http.Request) int { return 1 },
+		keyErrorFunc: func(rw http.ResponseWriter, r *http.Request, err error) {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(rw, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+		},
+		errorFunc: func(r *http.Request, err error) {
+			log.Printf("failed to get rate limiter decision: %s", err)
+		},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Handler rate limits requests before passing them to next
+// rejected requests get 429 with Retry-After, all requests get RateLimit and RateLimit-Policy headers
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		key, err := m.keyExtractor.ExtractKey(r)
+		if err != nil {
+			m.keyErrorFunc(rw, r, err)
+			return
+		}
+		if m.refundPolicy != nil {
+			m.serveWithRefund(next, rw, r, key)
+			return
+		}
+		decision, err := m.rateLimiter.GetDecisionWithCost(r.Context(), key, m.burstSize, m.rate, m.costFunc(r))
+		if err != nil {
+			m.errorFunc(r, err)
+		}
+		m.serve(next, rw, r, decision)
+	})
+}
+
+// serveWithRefund reserves tokens for the request and refunds them when the response matches refund policy
+func (m *Middleware) serveWithRefund(next http.Handler, rw http.ResponseWriter, r *http.Request, key string) {
+	reservation, err := m.rateLimiter.Reserve(r.Context(), key, m.burstSize, m.rate, m.costFunc(r))
+	if err != nil {
+		m.errorFunc(r, err)
+	}
+	recorder := &statusRecorder{ResponseWriter: rw, statusCode: http.StatusOK}
+	if !m.serve(next, recorder, r, reservation.Decision) || !m.refundPolicy(recorder.statusCode) {
+		return
+	}
+	// refund even when the client is gone
+	if err = reservation.Cancel(context.WithoutCancel(r.Context())); err != nil {
+		m.errorFunc(r, err)
+	}
+}
+
+// serve writes headers and passes allowed request to next, return false when the request is rejected
+func (m *Middleware) serve(next http.Handler, rw http.ResponseWriter, r *http.Request, decision ratelimiter.RateLimiterDecision) bool {
+	m.writeHeaders(rw.Header(), decision)
+	if !decision.Allowed {
+		rw.Header().Set(HeaderRetryAfter, strconv.Itoa(ceilSeconds(decision.RetryAfter)))
+		http.Error(rw, fmt.Sprintf("too many requests, retry after %s", decision.RetryAfter), http.StatusTooManyRequests)
+		return false
+	}
+	next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), decisionContextKey{}, decision)))
+	return true
+}
+
+// statusRecorder records the status code written by next handler, 200 if it's not written explicitly
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+}
+
+func (w *statusRecorder) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.statusCode = statusCode
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the original ResponseWriter
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (m *Middleware) writeHeaders(header http.Header, decision ratelimiter.RateLimiterDecision) {
+	// decision has no quota when rate limiter fails open
+	if decision.Limit == 0 {
+		return
+	}
+	window := decision.Window
+	if window == 0 {
+		// decisions of rate limiters not setting window, it's the time for an empty bucket to be full
+		window = time.Duration(m.burstSize) * m.rate
+	}
+	header.Set(HeaderRateLimitPolicy, fmt.Sprintf("%d;w=%d", decision.Limit, ceilSeconds(window)))
+	header.Set(HeaderRateLimit, fmt.Sprintf("limit=%d, remaining=%d, reset=%d", decision.Limit, decision.Remaining, ceilSeconds(time.Until(decision.ResetAt))))
+}
+
+// ceilSeconds rounds up duration to whole seconds, negative duration is 0
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
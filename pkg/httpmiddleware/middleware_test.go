@@ -0,0 +1,182 @@
+package httpmiddleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/rate-limiter/pkg/cache"
+	"github.com/Azure/rate-limiter/ratelimiter"
+	"github.com/Azure/rate-limiter/ratelimiter/mock_ratelimiter"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func headerKeyExtractor(r *http.Request) (string, error) {
+	key := r.Header.Get("x-key")
+	if key == "" {
+		return "", errors.New("missing key")
+	}
+	return key, nil
+}
+
+func TestMiddlewareAllowed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rateLimiter := mock_ratelimiter.NewMockRateLimiter(ctrl)
+	decision := ratelimiter.RateLimiterDecision{
+		Allowed:   true,
+		Limit:     10,
+		Remaining: 7,
+		ResetAt:   time.Now().Add(3 * time.Minute),
+	}
+	rateLimiter.EXPECT().GetDecisionWithCost(gomock.Any(), "id1", 10, time.Minute, 2).Return(decision, nil)
+
+	m := NewMiddleware(rateLimiter, KeyExtractorFunc(headerKeyExtractor), 10, time.Minute, WithCost(func(r *http.Request) int { return 2 }))
+	var got ratelimiter.RateLimiterDecision
+	handler := m.Handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		got, _ = DecisionFromContext(r.Context())
+		rw.WriteHeader(http.StatusCreated)
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("x-key", "id1")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, r)
+
+	assert.Equal(t, http.StatusCreated, rw.Code)
+	assert.Equal(t, decision, got)
+	assert.Equal(t, "10;w=600", rw.Header().Get(HeaderRateLimitPolicy))
+	assert.Equal(t, "limit=10, remaining=7, reset=180", rw.Header().Get(HeaderRateLimit))
+	assert.Empty(t, rw.Header().Get(HeaderRetryAfter))
+}
+
+func TestMiddlewarePolicyWindow(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rateLimiter := mock_ratelimiter.NewMockRateLimiter(ctrl)
+	// e.g. a fixed window of an hour, the window isn't burst size * rate
+	decision := ratelimiter.RateLimiterDecision{
+		Allowed:   true,
+		Limit:     10,
+		Remaining: 9,
+		ResetAt:   time.Now().Add(30 * time.Minute),
+		Window:    time.Hour,
+	}
+	rateLimiter.EXPECT().GetDecisionWithCost(gomock.Any(), "id1", 10, time.Minute, 1).Return(decision, nil)
+
+	m := NewMiddleware(rateLimiter, KeyExtractorFunc(headerKeyExtractor), 10, time.Minute)
+	handler := m.Handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("x-key", "id1")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, r)
+
+	assert.Equal(t, "10;w=3600", rw.Header().Get(HeaderRateLimitPolicy))
+}
+
+func TestMiddlewareRejected(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rateLimiter := mock_ratelimiter.NewMockRateLimiter(ctrl)
+	rateLimiter.EXPECT().GetDecisionWithCost(gomock.Any(), "id1", 10, time.Minute, 1).Return(ratelimiter.RateLimiterDecision{
+		Allowed:    false,
+		RetryAfter: 1500 * time.Millisecond,
+		Limit:      10,
+		ResetAt:    time.Now().Add(10 * time.Minute),
+	}, nil)
+
+	m := NewMiddleware(rateLimiter, KeyExtractorFunc(headerKeyExtractor), 10, time.Minute)
+	handler := m.Handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		t.Fatal("rejected request should not be passed to next handler")
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("x-key", "id1")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, r)
+
+	assert.Equal(t, http.StatusTooManyRequests, rw.Code)
+	assert.Equal(t, "2", rw.Header().Get(HeaderRetryAfter))
+	assert.Equal(t, "limit=10, remaining=0, reset=600", rw.Header().Get(HeaderRateLimit))
+}
+
+func TestMiddlewareKeyError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rateLimiter := mock_ratelimiter.NewMockRateLimiter(ctrl)
+
+	m := NewMiddleware(rateLimiter, KeyExtractorFunc(headerKeyExtractor), 10, time.Minute)
+	handler := m.Handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		t.Fatal("request without key should not be passed to next handler")
+	}))
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+
+	// body too large to find the key
+	m = NewMiddleware(rateLimiter, JSONBodyKeyExtractor("billingAccount", WithMaxBodyBytes(16)), 10, time.Minute)
+	handler = m.Handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		t.Fatal("request with too large body should not be passed to next handler")
+	}))
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"billingAccount":"id1","padding":"0123456789"}`)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rw.Code)
+}
+
+func TestMiddlewareFailOpen(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rateLimiter := mock_ratelimiter.NewMockRateLimiter(ctrl)
+	rateLimiter.EXPECT().GetDecisionWithCost(gomock.Any(), "id1", 10, time.Minute, 1).Return(ratelimiter.RateLimiterDecision{Allowed: true}, errors.New("redis is down"))
+
+	var handledErr error
+	m := NewMiddleware(rateLimiter, KeyExtractorFunc(headerKeyExtractor), 10, time.Minute, WithErrorHandler(func(r *http.Request, err error) {
+		handledErr = err
+	}))
+	handler := m.Handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("x-key", "id1")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, r)
+
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.NotNil(t, handledErr)
+	assert.Empty(t, rw.Header().Get(HeaderRateLimit))
+}
+
+func TestMiddlewareRefund(t *testing.T) {
+	rateLimiter := ratelimiter.NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), cache.NewMemCacheClient(time.Minute, time.Minute))
+	m := NewMiddleware(rateLimiter, KeyExtractorFunc(headerKeyExtractor), 1, time.Minute, WithRefundPolicy(RefundStatusCodes(http.StatusBadRequest)), WithErrorHandler(func(r *http.Request, err error) {
+		t.Errorf("unexpected error: %s", err)
+	}))
+	statusCode := http.StatusBadRequest
+	handler := m.Handler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(statusCode)
+	}))
+	serve := func() int {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set("x-key", "id1")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, r)
+		return rw.Code
+	}
+
+	// invalid requests don't count
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusBadRequest, serve())
+	}
+	statusCode = http.StatusCreated
+	assert.Equal(t, http.StatusCreated, serve())
+	assert.Equal(t, http.StatusTooManyRequests, serve())
+}
+
+func TestRefundPolicy(t *testing.T) {
+	assert.True(t, RefundClientErrors()(http.StatusNotFound))
+	assert.False(t, RefundClientErrors()(http.StatusInternalServerError))
+	assert.True(t, RefundStatusCodes(http.StatusConflict, http.StatusBadRequest)(http.StatusBadRequest))
+	assert.False(t, RefundStatusCodes(http.StatusConflict)(http.StatusOK))
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/rate-limiter/pkg/cache"
+)
+
+type cacheClient struct {
+	client  cache.CacheClient
+	backend string
+	metrics *Metrics
+}
+
+type scriptCacheClient struct {
+	cacheClient
+	scriptClient cache.ScriptCacheClient
+}
+
+// InstrumentCacheClient observes latency of every operation of client, backend is the label value, memory or remote
+// the returned client implements cache.ScriptCacheClient when client does, nil is returned for a nil client
+func (m *Metrics) InstrumentCacheClient(client cache.CacheClient, backend string) cache.CacheClient {
+	if client == nil {
+		return nil
+	}
+	c := cacheClient{client: client, backend: backend, metrics: m}
+	if scriptClient, ok := client.(cache.ScriptCacheClient); ok {
+		return &scriptCacheClient{cacheClient: c, scriptClient: scriptClient}
+	}
+	return &c
+}
+
+func (c *cacheClient) observe(operation string, start time.Time) {
+	c.metrics.cacheDuration.WithLabelValues(c.backend, operation).Observe(time.Since(start).Seconds())
+}
+
+func (c *cacheClient) UpdateCache(ctx context.Context, key string, cacheData map[string]string, expireTime time.Duration) error {
+	defer c.observe("update_cache", time.Now())
+	return c.client.UpdateCache(ctx, key, cacheData, expireTime)
+}
+
+func (c *cacheClient) GetCache(ctx context.Context, key string) (map[string]string, error) {
+	defer c.observe("get_cache", time.Now())
+	return c.client.GetCache(ctx, key)
+}
+
+func (c *scriptCacheClient) TakeTokens(ctx context.Context, key string, burstSize int, tokenDropRate time.Duration, cost int) (int, time.Time, time.Duration, error) {
+	defer c.observe("take_tokens", time.Now())
+	return c.scriptClient.TakeTokens(ctx, key, burstSize, tokenDropRate, cost)
+}
+
+func (c *scriptCacheClient) ReserveTokens(ctx context.Context, key string, burstSize int, tokenDropRate time.Duration, cost int, maxWait time.Duration) (int, time.Time, time.Duration, error) {
+	defer c.observe("reserve_tokens", time.Now())
+	return c.scriptClient.ReserveTokens(ctx, key, burstSize, tokenDropRate, cost, maxWait)
+}
+
+func (c *scriptCacheClient) RefundTokens(ctx context.Context, key string, burstSize int, tokenDropRate time.Duration, cost int) (int, time.Time, time.Duration, error) {
+	defer c.observe("refund_tokens", time.Now())
+	return c.scriptClient.RefundTokens(ctx, key, burstSize, tokenDropRate, cost)
+}
+
+func (c *scriptCacheClient) AcquireLease(ctx context.Context, key string, leaseID string, limit int, ttl time.Duration) (bool, int, error) {
+	defer c.observe("acquire_lease", time.Now())
+	return c.scriptClient.AcquireLease(ctx, key, leaseID, limit, ttl)
+}
+
+func (c *scriptCacheClient) ExtendLease(ctx context.Context, key string, leaseID string, ttl time.Duration) (bool, error) {
+	defer c.observe("extend_lease", time.Now())
+	return c.scriptClient.ExtendLease(ctx, key, leaseID, ttl)
+}
+
+func (c *scriptCacheClient) ReleaseLease(ctx context.Context, key string, leaseID string) error {
+	defer c.observe("release_lease", time.Now())
+	return c.scriptClient.ReleaseLease(ctx, key, leaseID)
+}
@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	DefaultNamespace      = "rate_limiter"
+	DefaultMaxLabelValues = 100
+	// OverflowLabelValue replaces label values after max label values are seen
+	OverflowLabelValue = "other"
+)
+
+// Metrics exports prometheus metrics of rate limiter decisions and cache clients
+// keys are never used as label values unless WithKeyLabel maps them to one
+type Metrics struct {
+	decisions     *prometheus.CounterVec
+	shadows       *prometheus.CounterVec
+	remoteErrors  *prometheus.CounterVec
+	fallbacks     *prometheus.CounterVec
+	cacheDuration *prometheus.HistogramVec
+	localEntries  prometheus.Gauge
+
+	namespace      string
+	keyLabel       func(key string) string
+	maxLabelValues int
+
+	mu          sync.Mutex
+	labelValues map[string]struct{}
+}
+
+type Option func(*Metrics)
+
+// WithNamespace sets the prefix of metric names, default is rate_limiter
+func WithNamespace(namespace string) Option {
+	return func(m *Metrics) {
+		m.namespace = namespace
+	}
+}
+
+// WithKeyLabel sets the policy label value of decisions made without a policy, it must map keys to a small set of values,
+// for example the key prefix before ':'. default is an empty value for all keys
+func WithKeyLabel(keyLabel func(key string) string) Option {
+	return func(m *Metrics) {
+		m.keyLabel = keyLabel
+	}
+}
+
+// WithMaxLabelValues limits the number of policy label values, new values are replaced by "other" after n values, default is 100
+func WithMaxLabelValues(n int) Option {
+	return func(m *Metrics) {
+		m.maxLabelValues = n
+	}
+}
+
+// NewMetrics creates the metrics and registers them with registerer
+func NewMetrics(registerer prometheus.Registerer, opts ...Option) (*Metrics, error) {
+	m := &Metrics{
+		namespace:      DefaultNamespace,
+		keyLabel:       func(string) string { return "" },
+		maxLabelValues: DefaultMaxLabelValues,
+		labelValues:    map[string]struct{}{},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.decisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.namespace,
+		Name:      "decisions_total",
+		Help:      "Number of rate limiter decisions by policy and result, allowed or denied.",
+	}, []string{"policy", "result"})
+	m.shadows = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.namespace,
+		Name:      "shadow_decisions_total",
+		Help:      "Number of decisions made in shadow mode by policy and result, allowed or denied, the requests are always allowed.",
+	}, []string{"policy", "result"})
+	m.remoteErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.namespace,
+		Name:      "remote_errors_total",
+		Help:      "Number of decisions failed by remote cache, by policy and reason, backend_unavailable or corrupt_state.",
+	}, []string{"policy", "reason"})
+	m.fallbacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.namespace,
+		Name:      "memory_fallbacks_total",
+		Help:      "Number of decisions made by memcache because remote cache failed, by policy.",
+	}, []string{"policy"})
+	m.cacheDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: m.namespace,
+		Name:      "cache_operation_duration_seconds",
+		Help:      "Latency of cache client operations by backend, memory or remote, and operation.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"backend", "operation"})
+	m.localEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: m.namespace,
+		Name:      "local_cache_entries",
+		Help:      "Number of entries in memcache, updated after every decision.",
+	})
+	var err error
+	for _, collector := range []prometheus.Collector{m.decisions, m.shadows, m.remoteErrors, m.fallbacks, m.cacheDuration, m.localEntries} {
+		err = errors.Join(err, registerer.Register(collector))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ObserveDecision counts a decision, policy is empty for decisions made without a policy
+func (m *Metrics) ObserveDecision(policy, key string, allowed bool) {
+	m.decisions.WithLabelValues(m.policyLabel(policy, key), result(allowed)).Inc()
+}
+
+// ObserveShadowDecision counts a decision made in shadow mode, denied is a would-be rejection
+func (m *Metrics) ObserveShadowDecision(policy, key string, allowed bool) {
+	m.shadows.WithLabelValues(m.policyLabel(policy, key), result(allowed)).Inc()
+}
+
+func result(allowed bool) string {
+	if allowed {
+		return "allowed"
+	}
+	return "denied"
+}
+
+// ObserveRemoteError counts a decision failed by remote cache
+func (m *Metrics) ObserveRemoteError(policy, key, reason string) {
+	m.remoteErrors.WithLabelValues(m.policyLabel(policy, key), reason).Inc()
+}
+
+// ObserveFallback counts a decision made by memcache because remote cache failed
+func (m *Metrics) ObserveFallback(policy, key string) {
+	m.fallbacks.WithLabelValues(m.policyLabel(policy, key)).Inc()
+}
+
+func (m *Metrics) SetLocalCacheEntries(n int) {
+	m.localEntries.Set(float64(n))
+}
+
+// policyLabel returns label value of policy, or of key when policy is empty
+func (m *Metrics) policyLabel(policy, key string) string {
+	value := policy
+	if value == "" {
+		value = m.keyLabel(key)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.labelValues[value]; ok {
+		return value
+	}
+	if len(m.labelValues) >= m.maxLabelValues {
+		return OverflowLabelValue
+	}
+	m.labelValues[value] = struct{}{}
+	return value
+}
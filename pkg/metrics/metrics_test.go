@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Azure/rate-limiter/pkg/cache"
+)
+
+func TestObserveDecision(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := NewMetrics(registry)
+	assert.Nil(t, err)
+
+	m.ObserveDecision("create", "billingAccount:id1", true)
+	m.ObserveDecision("create", "billingAccount:id2", false)
+	// keys aren't labels by default
+	m.ObserveDecision("", "billingAccount:id1", true)
+	m.ObserveRemoteError("create", "billingAccount:id1", "backend_unavailable")
+	m.ObserveFallback("create", "billingAccount:id1")
+	m.SetLocalCacheEntries(3)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.decisions.WithLabelValues("create", "allowed")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.decisions.WithLabelValues("create", "denied")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.decisions.WithLabelValues("", "allowed")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.remoteErrors.WithLabelValues("create", "backend_unavailable")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.fallbacks.WithLabelValues("create")))
+	assert.Equal(t, 3.0, testutil.ToFloat64(m.localEntries))
+
+	// metrics can't be registered twice
+	_, err = NewMetrics(registry)
+	assert.NotNil(t, err)
+}
+
+func TestPolicyLabelCardinality(t *testing.T) {
+	keyPrefix := func(key string) string {
+		prefix, _, _ := strings.Cut(key, ":")
+		return prefix
+	}
+	m, err := NewMetrics(prometheus.NewRegistry(), WithKeyLabel(keyPrefix), WithMaxLabelValues(2))
+	assert.Nil(t, err)
+
+	assert.Equal(t, "billingAccount", m.policyLabel("", "billingAccount:id1"))
+	assert.Equal(t, "create", m.policyLabel("create", "billingAccount:id1"))
+	assert.Equal(t, OverflowLabelValue, m.policyLabel("", "subscription:id1"))
+	// seen values are kept
+	assert.Equal(t, "billingAccount", m.policyLabel("", "billingAccount:id2"))
+}
+
+func TestInstrumentCacheClient(t *testing.T) {
+	ctx := context.Background()
+	m, err := NewMetrics(prometheus.NewRegistry())
+	assert.Nil(t, err)
+	server := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+
+	client := m.InstrumentCacheClient(cache.NewRedisClient(ctx, redisClient), "remote")
+	tokenBucketClient, ok := client.(cache.TokenBucketCacheClient)
+	assert.True(t, ok)
+	_, _, _, err = tokenBucketClient.TakeTokens(ctx, "id1", 2, time.Minute, 1)
+	assert.Nil(t, err)
+	_, err = client.GetCache(ctx, "id1")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, testutil.CollectAndCount(m.cacheDuration))
+
+	memClient := m.InstrumentCacheClient(cache.NewMemCacheClient(time.Minute, time.Minute), "memory")
+	_, ok = memClient.(cache.TokenBucketCacheClient)
+	assert.False(t, ok)
+	assert.Nil(t, m.InstrumentCacheClient(nil, "remote"))
+}
@@ -0,0 +1,296 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/Azure/rate-limiter/pkg/algorithm"
+)
+
+// FailMode decides the decision when the rate limiter fails
+type FailMode string
+
+const (
+	// FailOpen allows requests
+	FailOpen FailMode = "open"
+	// FailClosed rejects requests
+	FailClosed FailMode = "closed"
+	// FailLocal uses the memcache decision when remote cache fails, so requests are still limited per replica, and allows requests otherwise
+	FailLocal FailMode = "local"
+)
+
+// Policy is a named limit, and the keys and request attributes it applies to
+type Policy struct {
+	Name string `yaml:"name"`
+	// KeyPattern matches rate limit keys in path.Match syntax, e.g. "billingAccount:*", empty pattern matches any key
+	KeyPattern string `yaml:"key_pattern"`
+	// Attributes must all be equal to the request attributes, e.g. method: POST
+	Attributes map[string]string `yaml:"attributes"`
+	// Algorithm is one of token_bucket (default), sliding_window, gcra and fixed_window
+	Algorithm string        `yaml:"algorithm"`
+	BurstSize int           `yaml:"burst_size"`
+	Rate      time.Duration `yaml:"rate"`
+	// WindowUnit and Location are only used by fixed_window, location is an IANA time zone name, default is UTC
+	WindowUnit algorithm.WindowUnit `yaml:"window_unit"`
+	Location   string               `yaml:"location"`
+	// Cost is the number of tokens taken by a request, default is 1
+	Cost     int      `yaml:"cost"`
+	FailMode FailMode `yaml:"fail_mode"`
+	// Shadow evaluates the policy in separate buckets and reports would-be rejections, but always allows requests
+	Shadow bool `yaml:"shadow"`
+
+	factory algorithm.Factory
+}
+
+// file is the format of a policy file, e.g.
+// policies:
+//   - name: create-cluster
+//     key_pattern: "billingAccount:*"
+//     attributes: {method: POST}
+//     burst_size: 10
+//     rate: 1m
+type file struct {
+	Policies []Policy `yaml:"policies"`
+}
+
+var knownFields = map[string]bool{
+	"name":        true,
+	"key_pattern": true,
+	"attributes":  true,
+	"algorithm":   true,
+	"burst_size":  true,
+	"rate":        true,
+	"window_unit": true,
+	"location":    true,
+	"cost":        true,
+	"fail_mode":   true,
+	"shadow":      true,
+}
+
+// fieldError is a validation error of a policy field, the field is used to find the line of the error
+type fieldError struct {
+	field string
+	err   error
+}
+
+func (e *fieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.field, e.err)
+}
+
+func newFieldError(field string, format string, args ...interface{}) *fieldError {
+	return &fieldError{field: field, err: fmt.Errorf(format, args...)}
+}
+
+// Factory returns the algorithm factory of the policy
+func (p *Policy) Factory() algorithm.Factory {
+	return p.factory
+}
+
+// Matches checks if the policy applies to key and request attributes
+func (p *Policy) Matches(key string, attributes map[string]string) bool {
+	if p.KeyPattern != "" {
+		if matched, _ := path.Match(p.KeyPattern, key); !matched {
+			return false
+		}
+	}
+	for name, value := range p.Attributes {
+		if attributes[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// setDefaults sets empty fields to default values
+func (p *Policy) setDefaults() {
+	if p.Algorithm == "" {
+		p.Algorithm = algorithm.AlgorithmTokenBucket
+	}
+	if p.Cost == 0 {
+		p.Cost = 1
+	}
+	if p.FailMode == "" {
+		p.FailMode = FailLocal
+	}
+}
+
+// validate checks fields of the policy and builds its algorithm factory
+func (p *Policy) validate() error {
+	if p.Name == "" {
+		return newFieldError("name", "must not be empty")
+	}
+	if _, err := path.Match(p.KeyPattern, ""); err != nil {
+		return newFieldError("key_pattern", "invalid pattern %q", p.KeyPattern)
+	}
+	if p.Algorithm == algorithm.AlgorithmFixedWindow {
+		location, err := time.LoadLocation(p.Location)
+		if err != nil {
+			return newFieldError("location", "invalid location %q", p.Location)
+		}
+		p.factory = algorithm.NewFixedWindowAlgorithm(p.WindowUnit, location)
+	} else {
+		factory, err := algorithm.FactoryByName(p.Algorithm)
+		if err != nil {
+			return &fieldError{field: "algorithm", err: err}
+		}
+		p.factory = factory
+	}
+	if p.BurstSize <= 0 {
+		return newFieldError("burst_size", "must be greater than 0")
+	}
+	if p.Rate <= 0 && p.Algorithm != algorithm.AlgorithmFixedWindow {
+		return newFieldError("rate", "must be greater than 0")
+	}
+	algo, err := p.factory(p.BurstSize, p.Rate)
+	if err != nil {
+		field := "rate"
+		if p.Algorithm == algorithm.AlgorithmFixedWindow {
+			field = "window_unit"
+		}
+		return &fieldError{field: field, err: err}
+	}
+	if err = algo.ValidateCost(p.Cost); err != nil {
+		return &fieldError{field: "cost", err: err}
+	}
+	switch p.FailMode {
+	case FailOpen, FailClosed, FailLocal:
+	default:
+		return newFieldError("fail_mode", "invalid fail mode %q, must be open, closed or local", p.FailMode)
+	}
+	return nil
+}
+
+// Registry holds policies by name, and matches keys and request attributes to policies in the order they are defined
+type Registry struct {
+	policies []*Policy
+	byName   map[string]*Policy
+}
+
+// LoadRegistry loads policies from a YAML or JSON file
+func LoadRegistry(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	registry, err := ParseRegistry(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+	return registry, nil
+}
+
+// ParseRegistry parses policies in YAML or JSON, errors have the line of the wrong field
+func ParseRegistry(data []byte) (*Registry, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) == 0 {
+		return nil, errors.New("policy file is empty")
+	}
+	document := root.Content[0]
+	var f file
+	if err := document.Decode(&f); err != nil {
+		return nil, err
+	}
+	policyNodes := mappingValue(document, "policies")
+	if policyNodes == nil || len(f.Policies) == 0 {
+		return nil, fmt.Errorf("line %d: policies must not be empty", document.Line)
+	}
+	registry := &Registry{byName: make(map[string]*Policy, len(f.Policies))}
+	for i := range f.Policies {
+		node := policyNodes.Content[i]
+		for j := 0; j+1 < len(node.Content); j += 2 {
+			if field := node.Content[j]; !knownFields[field.Value] {
+				return nil, fmt.Errorf("line %d: unknown field %q", field.Line, field.Value)
+			}
+		}
+		if err := registry.add(&f.Policies[i]); err != nil {
+			line := node.Line
+			var fieldErr *fieldError
+			if errors.As(err, &fieldErr) {
+				if value := mappingValue(node, fieldErr.field); value != nil {
+					line = value.Line
+				}
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return registry, nil
+}
+
+func NewRegistry(policies ...Policy) (*Registry, error) {
+	registry := &Registry{byName: make(map[string]*Policy, len(policies))}
+	for i := range policies {
+		if err := registry.add(&policies[i]); err != nil {
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+func (r *Registry) add(p *Policy) error {
+	p.setDefaults()
+	if err := p.validate(); err != nil {
+		if p.Name != "" {
+			return fmt.Errorf("policy %s: %w", p.Name, err)
+		}
+		return err
+	}
+	if _, found := r.byName[p.Name]; found {
+		return newFieldError("name", "duplicate policy %s", p.Name)
+	}
+	r.policies = append(r.policies, p)
+	r.byName[p.Name] = p
+	return nil
+}
+
+// Get returns the policy of name
+func (r *Registry) Get(name string) (*Policy, bool) {
+	p, found := r.byName[name]
+	return p, found
+}
+
+// Match returns the first policy applying to key and request attributes, shadow policies are skipped
+func (r *Registry) Match(key string, attributes map[string]string) (*Policy, bool) {
+	for _, p := range r.policies {
+		if !p.Shadow && p.Matches(key, attributes) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// MatchShadow returns all shadow policies applying to key and request attributes, they are evaluated besides the matched policy
+func (r *Registry) MatchShadow(key string, attributes map[string]string) []*Policy {
+	var policies []*Policy
+	for _, p := range r.policies {
+		if p.Shadow && p.Matches(key, attributes) {
+			policies = append(policies, p)
+		}
+	}
+	return policies
+}
+
+// Policies returns all policies in the order they are defined
+func (r *Registry) Policies() []*Policy {
+	return r.policies
+}
+
+// mappingValue returns the value node of key in a mapping node, nil if not found
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Azure/rate-limiter/pkg/algorithm"
+)
+
+const testPolicies = `
+policies:
+  - name: create-cluster
+    key_pattern: "billingAccount:*"
+    attributes:
+      method: POST
+    burst_size: 10
+    rate: 1m
+    cost: 2
+    fail_mode: closed
+  - name: daily-quota
+    algorithm: fixed_window
+    window_unit: day
+    location: UTC
+    burst_size: 1000
+  - name: default
+    algorithm: gcra
+    burst_size: 100
+    rate: 100ms
+    shadow: true
+`
+
+func TestParseRegistry(t *testing.T) {
+	registry, err := ParseRegistry([]byte(testPolicies))
+	assert.Nil(t, err)
+	assert.Len(t, registry.Policies(), 3)
+
+	p, found := registry.Get("create-cluster")
+	assert.True(t, found)
+	assert.Equal(t, algorithm.AlgorithmTokenBucket, p.Algorithm)
+	assert.Equal(t, 10, p.BurstSize)
+	assert.Equal(t, time.Minute, p.Rate)
+	assert.Equal(t, 2, p.Cost)
+	assert.Equal(t, FailClosed, p.FailMode)
+	algo, err := p.Factory()(p.BurstSize, p.Rate)
+	assert.Nil(t, err)
+	assert.IsType(t, &algorithm.Bucket{}, algo)
+
+	p, found = registry.Get("daily-quota")
+	assert.True(t, found)
+	assert.Equal(t, 1, p.Cost)
+	assert.Equal(t, FailLocal, p.FailMode)
+	assert.False(t, p.Shadow)
+	algo, err = p.Factory()(p.BurstSize, p.Rate)
+	assert.Nil(t, err)
+	assert.IsType(t, &algorithm.FixedWindow{}, algo)
+
+	p, found = registry.Get("default")
+	assert.True(t, found)
+	assert.True(t, p.Shadow)
+
+	_, found = registry.Get("unknown")
+	assert.False(t, found)
+}
+
+func TestParseRegistryJSON(t *testing.T) {
+	registry, err := ParseRegistry([]byte(`{"policies": [{"name": "default", "algorithm": "sliding_window", "burst_size": 5, "rate": "1s"}]}`))
+	assert.Nil(t, err)
+	p, found := registry.Get("default")
+	assert.True(t, found)
+	assert.Equal(t, time.Second, p.Rate)
+}
+
+func TestRegistryMatch(t *testing.T) {
+	registry, err := ParseRegistry([]byte(testPolicies))
+	assert.Nil(t, err)
+
+	p, found := registry.Match("billingAccount:id1", map[string]string{"method": "POST"})
+	assert.True(t, found)
+	assert.Equal(t, "create-cluster", p.Name)
+
+	// policies are matched in the order they are defined
+	p, found = registry.Match("billingAccount:id1", map[string]string{"method": "GET"})
+	assert.True(t, found)
+	assert.Equal(t, "daily-quota", p.Name)
+
+	// shadow policies are only matched by MatchShadow
+	registry, err = NewRegistry(
+		Policy{Name: "create-v2", KeyPattern: "billingAccount:*", BurstSize: 1, Rate: time.Minute, Shadow: true},
+		Policy{Name: "create", KeyPattern: "billingAccount:*", BurstSize: 10, Rate: time.Minute},
+	)
+	assert.Nil(t, err)
+	p, found = registry.Match("billingAccount:id1", nil)
+	assert.True(t, found)
+	assert.Equal(t, "create", p.Name)
+	shadows := registry.MatchShadow("billingAccount:id1", nil)
+	assert.Len(t, shadows, 1)
+	assert.Equal(t, "create-v2", shadows[0].Name)
+	assert.Empty(t, registry.MatchShadow("subscription:id1", nil))
+}
+
+func TestParseRegistryValidation(t *testing.T) {
+	for _, c := range []struct {
+		config string
+		err    string
+	}{
+		{"policies:\n  - name: a\n    burst_size: 0\n    rate: 1s", "line 3: policy a: burst_size: must be greater than 0"},
+		{"policies:\n  - name: a\n    burst_size: 1\n    rate: 1s\n    algorithm: leaky", `line 5: policy a: algorithm: unknown algorithm "leaky"`},
+		{"policies:\n  - name: a\n    burst_size: 1\n    rate: 1s\n    cost: 2", "line 5: policy a: cost: cost exceeds burst size"},
+		{"policies:\n  - name: a\n    burst_size: 1\n    rate: 1s\n    fail_mode: maybe", `line 5: policy a: fail_mode: invalid fail mode "maybe", must be open, closed or local`},
+		{"policies:\n  - name: a\n    burst_size: 1\n    rate: 1s\n  - name: a\n    burst_size: 1\n    rate: 1s", "line 5: name: duplicate policy a"},
+		{"policies:\n  - name: a\n    burst_size: 1\n    rate: 1s\n    burst: 2", `line 5: unknown field "burst"`},
+		{"policies:\n  - name: a\n    burst_size: 1\n    rate: soon", "line 4: "},
+		{"policies:\n  - name: a\n    algorithm: fixed_window\n    window_unit: week\n    burst_size: 1", `line 4: policy a: window_unit: invalid window unit "week"`},
+		{"policies: []", "line 1: policies must not be empty"},
+		{"{\"policies\": [{\"name\": \"a\",\n \"burst_size\": 1, \"rate\": \"-1s\"}]}", "line 2: policy a: rate: must be greater than 0"},
+	} {
+		_, err := ParseRegistry([]byte(c.config))
+		if assert.NotNil(t, err, c.config) {
+			assert.Contains(t, err.Error(), c.err)
+		}
+	}
+}
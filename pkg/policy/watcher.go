@@ -0,0 +1,185 @@
+package policy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/rate-limiter/pkg/cache"
+)
+
+// RemoteConfigField is the field of the remote cache key holding the policy config
+const RemoteConfigField = "config"
+
+// Provider returns the active registry, policies may be reloaded between calls
+type Provider interface {
+	Registry() *Registry
+}
+
+// Registry implements Provider with itself, for policies never reloaded
+func (r *Registry) Registry() *Registry {
+	return r
+}
+
+// Version identifies a loaded policy config
+type Version struct {
+	// Hash is the sha256 of the config content
+	Hash     string
+	Source   string
+	LoadedAt time.Time
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%s@%s loaded at %s", v.Hash, v.Source, v.LoadedAt.Format(time.RFC3339))
+}
+
+type snapshot struct {
+	registry *Registry
+	version  Version
+}
+
+// Watcher reloads policies when the config file, or the remote cache key if set, changes
+// a new config is validated before swapping, the last good config is kept when the new one is wrong
+// the remote config overrides the file when it's set, so a config can be pushed to all replicas at once
+type Watcher struct {
+	path              string
+	remoteCacheClient cache.CacheClient
+	remoteKey         string
+	pollInterval      time.Duration
+	reloadFunc        func(version Version, err error)
+
+	current atomic.Pointer[snapshot]
+	// mu serializes reloads
+	mu sync.Mutex
+}
+
+type WatcherOption func(*Watcher)
+
+// WithRemoteConfig also watches the config in RemoteConfigField of key in remote cache
+func WithRemoteConfig(remoteCacheClient cache.CacheClient, key string) WatcherOption {
+	return func(w *Watcher) {
+		w.remoteCacheClient = remoteCacheClient
+		w.remoteKey = key
+	}
+}
+
+// WithPollInterval sets how often config is checked, default is 10s
+func WithPollInterval(interval time.Duration) WatcherOption {
+	return func(w *Watcher) {
+		w.pollInterval = interval
+	}
+}
+
+// WithReloadHandler is called after every reload of a changed config, err is set when the new config is rejected, default is logging
+func WithReloadHandler(reloadFunc func(version Version, err error)) WatcherOption {
+	return func(w *Watcher) {
+		w.reloadFunc = reloadFunc
+	}
+}
+
+// NewWatcher loads the config, it fails when no valid config can be loaded
+func NewWatcher(ctx context.Context, path string, opts ...WatcherOption) (*Watcher, error) {
+	w := &Watcher{
+		path:         path,
+		pollInterval: 10 * time.Second,
+		reloadFunc: func(version Version, err error) {
+			if err != nil {
+				log.Printf("failed to reload policies, keep using %s: %s", version, err)
+				return
+			}
+			log.Printf("policies reloaded: %s", version)
+		},
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if err := w.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Registry implements Provider with the last good config
+func (w *Watcher) Registry() *Registry {
+	return w.current.Load().registry
+}
+
+// Version returns the version of the active config
+func (w *Watcher) Version() Version {
+	return w.current.Load().version
+}
+
+// Run checks config every poll interval until ctx is done
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// errors are reported by reload handler
+			_ = w.Reload(ctx)
+		}
+	}
+}
+
+// Reload loads the config and swaps policies when it's changed and valid
+func (w *Watcher) Reload(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	data, source, err := w.load(ctx)
+	current := w.current.Load()
+	if err != nil {
+		if current != nil {
+			w.reloadFunc(current.version, err)
+		}
+		return err
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if current != nil && current.version.Hash == hash {
+		return nil
+	}
+	registry, err := ParseRegistry(data)
+	if err != nil {
+		err = fmt.Errorf("invalid policy config %s: %w", source, err)
+		if current != nil {
+			w.reloadFunc(current.version, err)
+		}
+		return err
+	}
+	next := &snapshot{
+		registry: registry,
+		version:  Version{Hash: hash, Source: source, LoadedAt: time.Now()},
+	}
+	w.current.Store(next)
+	w.reloadFunc(next.version, nil)
+	return nil
+}
+
+// load returns the remote config if it's set, otherwise the config file
+func (w *Watcher) load(ctx context.Context) ([]byte, string, error) {
+	if w.remoteCacheClient != nil {
+		currentCache, err := w.remoteCacheClient.GetCache(ctx, w.remoteKey)
+		if err != nil {
+			// keep the active config, a remote config would be replaced by the file otherwise
+			if w.current.Load() != nil {
+				return nil, "", fmt.Errorf("failed to load remote policy config %s: %w", w.remoteKey, err)
+			}
+		} else if config := currentCache[RemoteConfigField]; config != "" {
+			return []byte(config), "cache:" + w.remoteKey, nil
+		}
+	}
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, "file:" + w.path, nil
+}
@@ -0,0 +1,82 @@
+package policy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Azure/rate-limiter/pkg/cache"
+)
+
+const testPolicyV1 = "policies:\n  - name: default\n    burst_size: 10\n    rate: 1m\n"
+const testPolicyV2 = "policies:\n  - name: default\n    burst_size: 20\n    rate: 1m\n"
+
+func writeTestPolicyFile(t *testing.T, path string, content string) {
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0o600))
+}
+
+func TestWatcherReload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	writeTestPolicyFile(t, path, testPolicyV1)
+	var reloadErr error
+	w, err := NewWatcher(ctx, path, WithReloadHandler(func(version Version, err error) { reloadErr = err }))
+	assert.Nil(t, err)
+	v1 := w.Version()
+	assert.Equal(t, "file:"+path, v1.Source)
+	p, _ := w.Registry().Get("default")
+	assert.Equal(t, 10, p.BurstSize)
+
+	// unchanged config is not reloaded
+	assert.Nil(t, w.Reload(ctx))
+	assert.Equal(t, v1, w.Version())
+
+	// last good config is kept
+	writeTestPolicyFile(t, path, "policies:\n  - name: default\n    burst_size: -1\n")
+	assert.NotNil(t, w.Reload(ctx))
+	assert.NotNil(t, reloadErr)
+	assert.Equal(t, v1, w.Version())
+	p, _ = w.Registry().Get("default")
+	assert.Equal(t, 10, p.BurstSize)
+
+	writeTestPolicyFile(t, path, testPolicyV2)
+	assert.Nil(t, w.Reload(ctx))
+	assert.Nil(t, reloadErr)
+	assert.NotEqual(t, v1.Hash, w.Version().Hash)
+	p, _ = w.Registry().Get("default")
+	assert.Equal(t, 20, p.BurstSize)
+}
+
+func TestWatcherRemoteConfig(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	writeTestPolicyFile(t, path, testPolicyV1)
+	remoteClient := cache.NewMemCacheClient(time.Hour, time.Hour)
+	w, err := NewWatcher(ctx, path, WithRemoteConfig(remoteClient, "policies"), WithPollInterval(10*time.Millisecond), WithReloadHandler(func(Version, error) {}))
+	assert.Nil(t, err)
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go w.Run(runCtx)
+
+	// remote config overrides the file
+	assert.Nil(t, remoteClient.UpdateCache(ctx, "policies", map[string]string{RemoteConfigField: testPolicyV2}, time.Hour))
+	assert.Eventually(t, func() bool {
+		return w.Version().Source == "cache:policies"
+	}, time.Second, 10*time.Millisecond)
+	p, _ := w.Registry().Get("default")
+	assert.Equal(t, 20, p.BurstSize)
+}
+
+func TestNewWatcherInvalidConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	_, err := NewWatcher(context.Background(), path)
+	assert.NotNil(t, err)
+
+	writeTestPolicyFile(t, path, "policies: []")
+	_, err = NewWatcher(context.Background(), path)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,249 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/Azure/rate-limiter/pkg/cache"
+)
+
+const (
+	adaptiveKeyPrefix   = "adaptive:"
+	adaptiveLimitKey    = "limit"
+	adaptiveChangedKey  = "changedAt"
+	adaptiveDecreaseKey = "decreasedAt"
+)
+
+// Outcome is the result of a request allowed by the rate limiter, reported to AdaptiveRateLimiter
+type Outcome struct {
+	Latency time.Duration
+	Err     error
+	// Backpressure is set when the dependency asks to slow down explicitly, e.g. it responds 429 or 503
+	Backpressure bool
+}
+
+// AdaptiveRateLimiter adjusts the limit of every key by additive increase / multiplicative decrease of reported outcomes
+// burst size passed to it is the configured limit, the effective burst size moves between floor and ceiling ratio of it,
+// while the time for an empty bucket to be full stays the same, so the effective rate changes with it
+// effective limits are saved in remote cache, so all replicas converge to the same limit, memcache keeps the last known one
+type AdaptiveRateLimiter struct {
+	rateLimiter       RateLimiter
+	memCacheClient    cache.CacheClient
+	remoteCacheClient cache.CacheClient
+	floor             float64
+	ceiling           float64
+	increaseStep      float64
+	decreaseFactor    float64
+	latencyThreshold  time.Duration
+	adjustInterval    time.Duration
+	stateTTL          time.Duration
+}
+
+type AdaptiveOption func(*AdaptiveRateLimiter)
+
+// WithLimitBounds sets the range of effective limit as ratios of the configured burst size, default is 0.1 to 1
+func WithLimitBounds(floor, ceiling float64) AdaptiveOption {
+	return func(r *AdaptiveRateLimiter) {
+		r.floor = floor
+		r.ceiling = ceiling
+	}
+}
+
+// WithIncreaseStep sets the number of requests added to the limit by a good outcome, default is 1
+func WithIncreaseStep(step float64) AdaptiveOption {
+	return func(r *AdaptiveRateLimiter) {
+		r.increaseStep = step
+	}
+}
+
+// WithDecreaseFactor sets the factor the limit is multiplied by on a bad outcome, default is 0.5
+func WithDecreaseFactor(factor float64) AdaptiveOption {
+	return func(r *AdaptiveRateLimiter) {
+		r.decreaseFactor = factor
+	}
+}
+
+// WithLatencyThreshold makes outcomes slower than threshold bad, latency isn't checked by default
+func WithLatencyThreshold(threshold time.Duration) AdaptiveOption {
+	return func(r *AdaptiveRateLimiter) {
+		r.latencyThreshold = threshold
+	}
+}
+
+// WithAdjustInterval sets the min time between two changes of the same direction, default is 1s
+// so a burst of outcomes reported by many requests at the same time only changes the limit once
+func WithAdjustInterval(interval time.Duration) AdaptiveOption {
+	return func(r *AdaptiveRateLimiter) {
+		r.adjustInterval = interval
+	}
+}
+
+// WithAdaptiveStateTTL sets how long an effective limit is kept without outcomes, default is 1 hour
+func WithAdaptiveStateTTL(ttl time.Duration) AdaptiveOption {
+	return func(r *AdaptiveRateLimiter) {
+		r.stateTTL = ttl
+	}
+}
+
+func NewAdaptiveRateLimiter(rateLimiter RateLimiter, memCacheClient, remoteCacheClient cache.CacheClient, opts ...AdaptiveOption) *AdaptiveRateLimiter {
+	r := &AdaptiveRateLimiter{
+		rateLimiter:       rateLimiter,
+		memCacheClient:    memCacheClient,
+		remoteCacheClient: remoteCacheClient,
+		floor:             0.1,
+		ceiling:           1,
+		increaseStep:      1,
+		decreaseFactor:    0.5,
+		adjustInterval:    time.Second,
+		stateTTL:          time.Hour,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// adaptiveState is the effective limit of a key and when it was changed
+type adaptiveState struct {
+	limit       float64
+	changedAt   time.Time
+	decreasedAt time.Time
+}
+
+func (r *AdaptiveRateLimiter) GetDecision(ctx context.Context, key string, burstSize int, rate time.Duration) (RateLimiterDecision, error) {
+	return r.GetDecisionWithCost(ctx, key, burstSize, rate, 1)
+}
+
+func (r *AdaptiveRateLimiter) GetDecisionWithCost(ctx context.Context, key string, burstSize int, rate time.Duration, cost int) (RateLimiterDecision, error) {
+	burstSize, rate, err := r.effectiveLimit(ctx, key, burstSize, rate)
+	decision, err2 := r.rateLimiter.GetDecisionWithCost(ctx, key, burstSize, rate, cost)
+	return decision, errors.Join(err, err2)
+}
+
+func (r *AdaptiveRateLimiter) Reserve(ctx context.Context, key string, burstSize int, rate time.Duration, cost int) (*Reservation, error) {
+	burstSize, rate, err := r.effectiveLimit(ctx, key, burstSize, rate)
+	reservation, err2 := r.rateLimiter.Reserve(ctx, key, burstSize, rate, cost)
+	return reservation, errors.Join(err, err2)
+}
+
+func (r *AdaptiveRateLimiter) Wait(ctx context.Context, key string, burstSize int, rate time.Duration, cost int) error {
+	// the last known or configured limit is used when cache fails
+	burstSize, rate, _ = r.effectiveLimit(ctx, key, burstSize, rate)
+	return r.rateLimiter.Wait(ctx, key, burstSize, rate, cost)
+}
+
+// EffectiveLimit returns the burst size and rate used for key now
+func (r *AdaptiveRateLimiter) EffectiveLimit(ctx context.Context, key string, burstSize int, rate time.Duration) (int, time.Duration, error) {
+	return r.effectiveLimit(ctx, key, burstSize, rate)
+}
+
+// ReportOutcome adjusts the limit of key by the outcome of a request, burst size is the configured one passed to GetDecision
+// an outcome with error, backpressure or latency over threshold decreases the limit, others increase it
+func (r *AdaptiveRateLimiter) ReportOutcome(ctx context.Context, key string, burstSize int, outcome Outcome) error {
+	floor, ceiling := r.bounds(burstSize)
+	// last known limit in memcache is adjusted when remote cache fails
+	state, err := r.getState(ctx, key, floor, ceiling)
+	now := time.Now()
+	bad := outcome.Err != nil || outcome.Backpressure || (r.latencyThreshold > 0 && outcome.Latency > r.latencyThreshold)
+	switch {
+	case bad && now.Sub(state.decreasedAt) >= r.adjustInterval:
+		state.limit = math.Max(state.limit*r.decreaseFactor, floor)
+		state.decreasedAt = now
+	case !bad && now.Sub(state.changedAt) >= r.adjustInterval && state.limit < ceiling:
+		state.limit = math.Min(state.limit+r.increaseStep, ceiling)
+	default:
+		return err
+	}
+	state.changedAt = now
+	return errors.Join(err, r.saveState(ctx, key, state))
+}
+
+func (r *AdaptiveRateLimiter) effectiveLimit(ctx context.Context, key string, burstSize int, rate time.Duration) (int, time.Duration, error) {
+	if burstSize <= 0 {
+		// wrong config is reported by the wrapped rate limiter
+		return burstSize, rate, nil
+	}
+	floor, ceiling := r.bounds(burstSize)
+	state, err := r.getState(ctx, key, floor, ceiling)
+	effectiveBurstSize := max(int(state.limit), 1)
+	// time for an empty bucket to be full is the same as the configured one
+	window := time.Duration(burstSize) * rate
+	return effectiveBurstSize, window / time.Duration(effectiveBurstSize), err
+}
+
+// bounds returns the range of the limit of a configured burst size
+func (r *AdaptiveRateLimiter) bounds(burstSize int) (float64, float64) {
+	floor := math.Max(r.floor*float64(burstSize), 1)
+	return floor, math.Max(r.ceiling*float64(burstSize), floor)
+}
+
+// getState reads state from remote cache, memcache is used when remote cache fails, a new key starts at ceiling
+func (r *AdaptiveRateLimiter) getState(ctx context.Context, key string, floor, ceiling float64) (adaptiveState, error) {
+	var currentCache map[string]string
+	var err error
+	if r.remoteCacheClient != nil {
+		currentCache, err = r.remoteCacheClient.GetCache(ctx, adaptiveKeyPrefix+key)
+		if err != nil {
+			err = backendError(err)
+		} else if len(currentCache) > 0 {
+			// keep the last known limit for remote cache failures
+			_ = r.memCacheClient.UpdateCache(ctx, adaptiveKeyPrefix+key, currentCache, r.stateTTL)
+		}
+	} else {
+		err = fmt.Errorf("%w: cache client is nil", ErrBackendUnavailable)
+	}
+	if err != nil {
+		currentCache, _ = r.memCacheClient.GetCache(ctx, adaptiveKeyPrefix+key)
+	}
+	state, parseErr := parseAdaptiveState(currentCache, floor, ceiling)
+	if parseErr != nil {
+		parseErr = fmt.Errorf("%w: %w", ErrCorruptState, parseErr)
+	}
+	return state, errors.Join(err, parseErr)
+}
+
+func (r *AdaptiveRateLimiter) saveState(ctx context.Context, key string, state adaptiveState) error {
+	cacheData := map[string]string{
+		adaptiveLimitKey:    strconv.FormatFloat(state.limit, 'f', -1, 64),
+		adaptiveChangedKey:  strconv.FormatInt(state.changedAt.UnixMilli(), 10),
+		adaptiveDecreaseKey: strconv.FormatInt(state.decreasedAt.UnixMilli(), 10),
+	}
+	// memcache won't return any error
+	_ = r.memCacheClient.UpdateCache(ctx, adaptiveKeyPrefix+key, cacheData, r.stateTTL)
+	if r.remoteCacheClient == nil {
+		return fmt.Errorf("%w: cache client is nil", ErrBackendUnavailable)
+	}
+	if err := r.remoteCacheClient.UpdateCache(ctx, adaptiveKeyPrefix+key, cacheData, r.stateTTL); err != nil {
+		return backendError(err)
+	}
+	return nil
+}
+
+// parseAdaptiveState returns state saved in cache, limit is clamped to the bounds since they may be changed by config
+func parseAdaptiveState(currentCache map[string]string, floor, ceiling float64) (adaptiveState, error) {
+	state := adaptiveState{limit: ceiling}
+	if len(currentCache) == 0 {
+		return state, nil
+	}
+	limit, err := strconv.ParseFloat(currentCache[adaptiveLimitKey], 64)
+	if err != nil || limit <= 0 {
+		return state, errors.New("wrong adaptive limit")
+	}
+	changedAt, err := strconv.ParseInt(currentCache[adaptiveChangedKey], 10, 64)
+	if err != nil {
+		return state, err
+	}
+	decreasedAt, err := strconv.ParseInt(currentCache[adaptiveDecreaseKey], 10, 64)
+	if err != nil {
+		return state, err
+	}
+	return adaptiveState{
+		limit:       math.Min(math.Max(limit, floor), ceiling),
+		changedAt:   time.UnixMilli(changedAt),
+		decreasedAt: time.UnixMilli(decreasedAt),
+	}, nil
+}
@@ -0,0 +1,91 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Azure/rate-limiter/pkg/cache"
+)
+
+func newTestAdaptiveRateLimiter(remoteCacheClient cache.CacheClient, opts ...AdaptiveOption) *AdaptiveRateLimiter {
+	memClient := cache.NewMemCacheClient(time.Minute, time.Minute)
+	rateLimiter := NewTokenBucketRateLimiter(memClient, remoteCacheClient)
+	return NewAdaptiveRateLimiter(rateLimiter, cache.NewMemCacheClient(time.Minute, time.Minute), remoteCacheClient, opts...)
+}
+
+func TestAdaptiveRateLimiterAIMD(t *testing.T) {
+	ctx := context.Background()
+	limiter := newTestAdaptiveRateLimiter(newTestRedisCacheClient(t), WithAdjustInterval(0), WithLatencyThreshold(time.Second), WithIncreaseStep(2))
+
+	burstSize, rate, err := limiter.EffectiveLimit(ctx, "id1", 10, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, burstSize)
+	assert.Equal(t, time.Second, rate)
+
+	assert.Nil(t, limiter.ReportOutcome(ctx, "id1", 10, Outcome{Err: errors.New("internal error")}))
+	burstSize, rate, err = limiter.EffectiveLimit(ctx, "id1", 10, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, burstSize)
+	// an empty bucket is still full in 10s
+	assert.Equal(t, 2*time.Second, rate)
+
+	// decreased to floor at most
+	for _, outcome := range []Outcome{{Backpressure: true}, {Latency: 2 * time.Second}, {Backpressure: true}, {Backpressure: true}} {
+		assert.Nil(t, limiter.ReportOutcome(ctx, "id1", 10, outcome))
+	}
+	burstSize, _, _ = limiter.EffectiveLimit(ctx, "id1", 10, time.Second)
+	assert.Equal(t, 1, burstSize)
+
+	// increased to ceiling at most
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, limiter.ReportOutcome(ctx, "id1", 10, Outcome{Latency: time.Millisecond}))
+	}
+	burstSize, _, _ = limiter.EffectiveLimit(ctx, "id1", 10, time.Second)
+	assert.Equal(t, 10, burstSize)
+}
+
+func TestAdaptiveRateLimiterAdjustInterval(t *testing.T) {
+	ctx := context.Background()
+	limiter := newTestAdaptiveRateLimiter(newTestRedisCacheClient(t))
+
+	// outcomes reported at the same time only decrease once
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, limiter.ReportOutcome(ctx, "id1", 10, Outcome{Backpressure: true}))
+	}
+	assert.Nil(t, limiter.ReportOutcome(ctx, "id1", 10, Outcome{}))
+	burstSize, _, _ := limiter.EffectiveLimit(ctx, "id1", 10, time.Second)
+	assert.Equal(t, 5, burstSize)
+}
+
+func TestAdaptiveRateLimiterSharedLimit(t *testing.T) {
+	ctx := context.Background()
+	remoteClient := newTestRedisCacheClient(t)
+	replica1 := newTestAdaptiveRateLimiter(remoteClient)
+	replica2 := newTestAdaptiveRateLimiter(remoteClient)
+
+	assert.Nil(t, replica1.ReportOutcome(ctx, "id1", 10, Outcome{Backpressure: true}))
+	for i := 0; i < 5; i++ {
+		decision, err := replica2.GetDecision(ctx, "id1", 10, time.Minute)
+		assert.Nil(t, err)
+		assert.True(t, decision.Allowed)
+		assert.Equal(t, 5, decision.Limit)
+	}
+	decision, err := replica2.GetDecision(ctx, "id1", 10, time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, decision.Allowed)
+}
+
+func TestAdaptiveRateLimiterRemoteCacheFailure(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewAdaptiveRateLimiter(NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), nil), cache.NewMemCacheClient(time.Minute, time.Minute), nil)
+
+	// limit is still adjusted locally
+	assert.ErrorIs(t, limiter.ReportOutcome(ctx, "id1", 10, Outcome{Backpressure: true}), ErrBackendUnavailable)
+	burstSize, _, err := limiter.EffectiveLimit(ctx, "id1", 10, time.Second)
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+	assert.Equal(t, 5, burstSize)
+}
@@ -0,0 +1,267 @@
+package ratelimiter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Azure/rate-limiter/pkg/cache"
+)
+
+const DefaultLeaseTTL = 30 * time.Second
+
+// ConcurrencyLimiter limits the number of requests in flight per key, e.g. at most 3 create operations per billing account
+// every request holds a lease in remote cache until it's released, a lease expires after lease ttl so slots held by crashed replicas are recovered,
+// and leases of long running requests are extended by heartbeat
+// memcache is used when remote cache fails, then only requests of this replica are limited
+type ConcurrencyLimiter struct {
+	memCacheClient    cache.CacheClient
+	remoteCacheClient cache.CacheClient
+	leaseTTL          time.Duration
+	heartbeatInterval time.Duration
+	// mu serializes reading and updating leases of cache clients without atomic lease operations
+	mu sync.Mutex
+}
+
+type ConcurrencyOption func(*ConcurrencyLimiter)
+
+// WithLeaseTTL sets how long a lease is held without heartbeat, default is DefaultLeaseTTL
+func WithLeaseTTL(ttl time.Duration) ConcurrencyOption {
+	return func(l *ConcurrencyLimiter) {
+		l.leaseTTL = ttl
+	}
+}
+
+// WithHeartbeatInterval sets how often held leases are extended, default is a third of lease ttl, 0 disables heartbeat
+func WithHeartbeatInterval(interval time.Duration) ConcurrencyOption {
+	return func(l *ConcurrencyLimiter) {
+		l.heartbeatInterval = interval
+	}
+}
+
+func NewConcurrencyLimiter(memCacheClient, remoteCacheClient cache.CacheClient, opts ...ConcurrencyOption) *ConcurrencyLimiter {
+	l := &ConcurrencyLimiter{
+		memCacheClient:    memCacheClient,
+		remoteCacheClient: remoteCacheClient,
+		leaseTTL:          DefaultLeaseTTL,
+		heartbeatInterval: -1,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.heartbeatInterval < 0 {
+		l.heartbeatInterval = l.leaseTTL / 3
+	}
+	return l
+}
+
+// Lease is a slot acquired by Acquire, it must be released when the request completes
+type Lease struct {
+	Acquired bool
+	Limit    int
+	// InFlight is the number of leases held including this one, or held by others when not acquired
+	InFlight int
+	Backend  Backend
+
+	limiter     *ConcurrencyLimiter
+	client      cache.CacheClient
+	key         string
+	id          string
+	stop        chan struct{}
+	lost        chan struct{}
+	releaseOnce sync.Once
+}
+
+// Acquire takes a slot of key when less than limit requests are in flight
+// rate limiter fails open when both caches fail, the returned lease is acquired and error is returned
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context, key string, limit int) (*Lease, error) {
+	if limit <= 0 {
+		// wrong config, fail open
+		return &Lease{Acquired: true}, fmt.Errorf("%w: limit must be greater than 0", ErrInvalidConfig)
+	}
+	if l.leaseTTL < time.Millisecond {
+		return &Lease{Acquired: true}, fmt.Errorf("%w: lease ttl must be at least 1ms", ErrInvalidConfig)
+	}
+	id, err := newLeaseID()
+	if err != nil {
+		return &Lease{Acquired: true}, err
+	}
+	lease := &Lease{
+		Limit:   limit,
+		Backend: BackendRemote,
+		limiter: l,
+		client:  l.remoteCacheClient,
+		key:     key,
+		id:      id,
+		stop:    make(chan struct{}),
+		lost:    make(chan struct{}),
+	}
+	acquired, inFlight, err := l.acquireLease(ctx, l.remoteCacheClient, key, id, limit)
+	if err != nil {
+		var memErr error
+		lease.Backend = BackendMemory
+		lease.client = l.memCacheClient
+		if acquired, inFlight, memErr = l.acquireLease(ctx, l.memCacheClient, key, id, limit); memErr != nil {
+			return &Lease{Acquired: true}, err
+		}
+	}
+	lease.Acquired = acquired
+	lease.InFlight = inFlight
+	if acquired && l.heartbeatInterval > 0 {
+		go lease.heartbeat()
+	}
+	return lease, err
+}
+
+// Release gives the slot back and stops heartbeat, it's safe to release a lease more than once
+func (l *Lease) Release(ctx context.Context) error {
+	var err error
+	l.releaseOnce.Do(func() {
+		if l.stop != nil {
+			close(l.stop)
+		}
+		if !l.Acquired || l.client == nil {
+			return
+		}
+		err = l.limiter.releaseLease(ctx, l.client, l.key, l.id)
+	})
+	return err
+}
+
+// Lost is closed when heartbeat finds the lease expired, the slot may be taken by another request then
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+func (l *Lease) heartbeat() {
+	ticker := time.NewTicker(l.limiter.heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), l.limiter.heartbeatInterval)
+			extended, err := l.limiter.extendLease(ctx, l.client, l.key, l.id)
+			cancel()
+			// retry on next tick when cache fails
+			if err == nil && !extended {
+				close(l.lost)
+				return
+			}
+		}
+	}
+}
+
+func newLeaseID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// acquireLease prefers acquiring on the server side, reading and then updating leases isn't atomic across replicas
+func (l *ConcurrencyLimiter) acquireLease(ctx context.Context, client cache.CacheClient, key string, id string, limit int) (bool, int, error) {
+	if client == nil {
+		return false, 0, fmt.Errorf("%w: cache client is nil", ErrBackendUnavailable)
+	}
+	if concurrencyClient, ok := client.(cache.ConcurrencyCacheClient); ok {
+		acquired, inFlight, err := concurrencyClient.AcquireLease(ctx, key, id, limit, l.leaseTTL)
+		if err != nil {
+			return false, 0, backendError(err)
+		}
+		return acquired, inFlight, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	leases, lastExpireAt, err := getLeasesFromCache(ctx, client, key, now)
+	if err != nil {
+		return false, 0, err
+	}
+	if len(leases) >= limit {
+		return false, len(leases), nil
+	}
+	expireAt := now.Add(l.leaseTTL)
+	leases[id] = strconv.FormatInt(expireAt.UnixMilli(), 10)
+	if expireAt.After(lastExpireAt) {
+		lastExpireAt = expireAt
+	}
+	if err = client.UpdateCache(ctx, key, leases, lastExpireAt.Sub(now)); err != nil {
+		return false, 0, backendError(err)
+	}
+	return true, len(leases), nil
+}
+
+func (l *ConcurrencyLimiter) extendLease(ctx context.Context, client cache.CacheClient, key string, id string) (bool, error) {
+	if concurrencyClient, ok := client.(cache.ConcurrencyCacheClient); ok {
+		return concurrencyClient.ExtendLease(ctx, key, id, l.leaseTTL)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	leases, lastExpireAt, err := getLeasesFromCache(ctx, client, key, now)
+	if err != nil {
+		return false, err
+	}
+	if _, found := leases[id]; !found {
+		return false, nil
+	}
+	expireAt := now.Add(l.leaseTTL)
+	leases[id] = strconv.FormatInt(expireAt.UnixMilli(), 10)
+	if expireAt.After(lastExpireAt) {
+		lastExpireAt = expireAt
+	}
+	return true, client.UpdateCache(ctx, key, leases, lastExpireAt.Sub(now))
+}
+
+func (l *ConcurrencyLimiter) releaseLease(ctx context.Context, client cache.CacheClient, key string, id string) error {
+	if concurrencyClient, ok := client.(cache.ConcurrencyCacheClient); ok {
+		if err := concurrencyClient.ReleaseLease(ctx, key, id); err != nil {
+			return backendError(err)
+		}
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	leases, lastExpireAt, err := getLeasesFromCache(ctx, client, key, now)
+	if err != nil {
+		return err
+	}
+	if _, found := leases[id]; !found {
+		return nil
+	}
+	// released lease is saved as expired, cache clients merging hash fields keep the old value otherwise
+	leases[id] = "0"
+	if err = client.UpdateCache(ctx, key, leases, max(lastExpireAt.Sub(now), time.Millisecond)); err != nil {
+		return backendError(err)
+	}
+	return nil
+}
+
+// getLeasesFromCache returns leases not expired at now and the time the last of them expires
+func getLeasesFromCache(ctx context.Context, client cache.CacheClient, key string, now time.Time) (map[string]string, time.Time, error) {
+	currentCache, err := client.GetCache(ctx, key)
+	if err != nil {
+		return nil, time.Time{}, backendError(err)
+	}
+	leases := make(map[string]string, len(currentCache)+1)
+	lastExpireAt := now
+	for id, value := range currentCache {
+		expireAt, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || expireAt <= now.UnixMilli() {
+			continue
+		}
+		leases[id] = value
+		if t := time.UnixMilli(expireAt); t.After(lastExpireAt) {
+			lastExpireAt = t
+		}
+	}
+	return leases, lastExpireAt, nil
+}
@@ -0,0 +1,106 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Azure/rate-limiter/pkg/cache"
+)
+
+func TestConcurrencyLimiterAcquire(t *testing.T) {
+	ctx := context.Background()
+	for _, remoteCacheClient := range []cache.CacheClient{newTestRedisCacheClient(t), cache.NewMemCacheClient(time.Minute, time.Minute)} {
+		limiter := NewConcurrencyLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), remoteCacheClient)
+
+		leases := make([]*Lease, 0, 3)
+		for i := 0; i < 3; i++ {
+			lease, err := limiter.Acquire(ctx, "id1", 3)
+			assert.Nil(t, err)
+			assert.True(t, lease.Acquired)
+			assert.Equal(t, i+1, lease.InFlight)
+			assert.Equal(t, BackendRemote, lease.Backend)
+			leases = append(leases, lease)
+		}
+		rejected, err := limiter.Acquire(ctx, "id1", 3)
+		assert.Nil(t, err)
+		assert.False(t, rejected.Acquired)
+		assert.Equal(t, 3, rejected.InFlight)
+		assert.Nil(t, rejected.Release(ctx))
+
+		// other keys have their own slots
+		lease, err := limiter.Acquire(ctx, "id2", 3)
+		assert.Nil(t, err)
+		assert.True(t, lease.Acquired)
+
+		assert.Nil(t, leases[0].Release(ctx))
+		assert.Nil(t, leases[0].Release(ctx))
+		lease, err = limiter.Acquire(ctx, "id1", 3)
+		assert.Nil(t, err)
+		assert.True(t, lease.Acquired)
+		assert.Equal(t, 3, lease.InFlight)
+	}
+}
+
+func TestConcurrencyLimiterLeaseExpired(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewConcurrencyLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), newTestRedisCacheClient(t), WithLeaseTTL(50*time.Millisecond), WithHeartbeatInterval(0))
+
+	lease, err := limiter.Acquire(ctx, "id1", 1)
+	assert.Nil(t, err)
+	assert.True(t, lease.Acquired)
+
+	// the holder crashed without releasing
+	time.Sleep(100 * time.Millisecond)
+	lease, err = limiter.Acquire(ctx, "id1", 1)
+	assert.Nil(t, err)
+	assert.True(t, lease.Acquired)
+}
+
+func TestConcurrencyLimiterHeartbeat(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewConcurrencyLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), newTestRedisCacheClient(t), WithLeaseTTL(60*time.Millisecond), WithHeartbeatInterval(20*time.Millisecond))
+
+	lease, err := limiter.Acquire(ctx, "id1", 1)
+	assert.Nil(t, err)
+	assert.True(t, lease.Acquired)
+
+	time.Sleep(150 * time.Millisecond)
+	rejected, err := limiter.Acquire(ctx, "id1", 1)
+	assert.Nil(t, err)
+	assert.False(t, rejected.Acquired)
+	select {
+	case <-lease.Lost():
+		t.Fatal("lease should be kept by heartbeat")
+	default:
+	}
+
+	assert.Nil(t, lease.Release(ctx))
+	lease, err = limiter.Acquire(ctx, "id1", 1)
+	assert.Nil(t, err)
+	assert.True(t, lease.Acquired)
+}
+
+func TestConcurrencyLimiterFallback(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewConcurrencyLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), nil)
+
+	lease, err := limiter.Acquire(ctx, "id1", 1)
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+	assert.True(t, lease.Acquired)
+	assert.Equal(t, BackendMemory, lease.Backend)
+	rejected, _ := limiter.Acquire(ctx, "id1", 1)
+	assert.False(t, rejected.Acquired)
+
+	assert.Nil(t, lease.Release(ctx))
+	lease, _ = limiter.Acquire(ctx, "id1", 1)
+	assert.True(t, lease.Acquired)
+
+	// wrong config fails open
+	lease, err = limiter.Acquire(ctx, "id1", 0)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.True(t, lease.Acquired)
+	assert.Nil(t, lease.Release(ctx))
+}
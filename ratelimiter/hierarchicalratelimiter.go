@@ -0,0 +1,109 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Limit is one level of a hierarchical decision, e.g. the bucket of a user, a subscription or the whole service
+type Limit struct {
+	Key       string
+	BurstSize int
+	Rate      time.Duration
+}
+
+type HierarchicalDecision struct {
+	// RateLimiterDecision is the combined decision: allowed only when every level allows, RetryAfter is the largest one of rejecting levels,
+	// Limit, Remaining, ResetAt and Backend are of the level with the fewest remaining tokens
+	RateLimiterDecision
+	// RejectedLevel is the index of the first level rejecting the request, -1 when allowed
+	RejectedLevel int
+	// Levels are decisions of evaluated levels in the same order as limits, levels after the rejecting one are peeked
+	Levels []RateLimiterDecision
+}
+
+// RejectedLimit returns the first limit rejecting the request, false when allowed
+func (d HierarchicalDecision) RejectedLimit(limits []Limit) (Limit, bool) {
+	if d.RejectedLevel < 0 || d.RejectedLevel >= len(limits) {
+		return Limit{}, false
+	}
+	return limits[d.RejectedLevel], true
+}
+
+// HierarchicalRateLimiter makes one decision of several levels of limits
+// levels are reserved in order until one rejects, then the reserved tokens are given back,
+// so an inner level rejecting doesn't drain outer levels
+type HierarchicalRateLimiter struct {
+	rateLimiter RateLimiter
+}
+
+// peeker is implemented by rate limiters which can make a decision without taking tokens
+type peeker interface {
+	Peek(ctx context.Context, key string, burstSize int, rate time.Duration, cost int) (RateLimiterDecision, error)
+}
+
+func NewHierarchicalRateLimiter(rateLimiter RateLimiter) *HierarchicalRateLimiter {
+	return &HierarchicalRateLimiter{
+		rateLimiter: rateLimiter,
+	}
+}
+
+// GetDecision takes cost tokens from every level of limits, which are ordered from the innermost level usually
+// levels after the rejecting one are peeked without taking tokens when the rate limiter implements Peek, e.g. TokenBucketRateLimiter,
+// otherwise they are not evaluated and missing in Levels
+func (r *HierarchicalRateLimiter) GetDecision(ctx context.Context, limits []Limit, cost int) (HierarchicalDecision, error) {
+	if len(limits) == 0 {
+		// wrong config, fail open
+		return HierarchicalDecision{RateLimiterDecision: RateLimiterDecision{Allowed: true}, RejectedLevel: -1}, errors.New("limits must not be empty")
+	}
+	decision := HierarchicalDecision{
+		RateLimiterDecision: RateLimiterDecision{Allowed: true},
+		RejectedLevel:       -1,
+		Levels:              make([]RateLimiterDecision, 0, len(limits)),
+	}
+	reservations := make([]*Reservation, 0, len(limits))
+	var errs []error
+	for i, limit := range limits {
+		var levelDecision RateLimiterDecision
+		var err error
+		if decision.Allowed {
+			var reservation *Reservation
+			reservation, err = r.rateLimiter.Reserve(ctx, limit.Key, limit.BurstSize, limit.Rate, cost)
+			reservations = append(reservations, reservation)
+			levelDecision = reservation.Decision
+		} else if peeker, ok := r.rateLimiter.(peeker); ok {
+			// only retry after is needed from outer levels, nothing is taken
+			levelDecision, err = peeker.Peek(ctx, limit.Key, limit.BurstSize, limit.Rate, cost)
+		} else {
+			break
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("level %d %s: %w", i, limit.Key, err))
+		}
+		decision.Levels = append(decision.Levels, levelDecision)
+		if !levelDecision.Allowed {
+			decision.Allowed = false
+			if decision.RejectedLevel < 0 {
+				decision.RejectedLevel = i
+			}
+			decision.RetryAfter = max(decision.RetryAfter, levelDecision.RetryAfter)
+		}
+		if i == 0 || levelDecision.Remaining < decision.Remaining {
+			decision.Limit = levelDecision.Limit
+			decision.Remaining = levelDecision.Remaining
+			decision.ResetAt = levelDecision.ResetAt
+			decision.Backend = levelDecision.Backend
+		}
+	}
+	if !decision.Allowed {
+		// give back tokens taken by allowed levels
+		for i, reservation := range reservations {
+			if err := reservation.Cancel(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("level %d %s: %w", i, limits[i].Key, err))
+			}
+		}
+	}
+	return decision, errors.Join(errs...)
+}
@@ -0,0 +1,112 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Azure/rate-limiter/pkg/cache"
+)
+
+func TestHierarchicalRateLimiter(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), newTestRedisCacheClient(t))
+	limiter := NewHierarchicalRateLimiter(rateLimiter)
+	user1Limits := []Limit{
+		{Key: "user1", BurstSize: 2, Rate: time.Minute},
+		{Key: "subscription1", BurstSize: 3, Rate: 30 * time.Second},
+		{Key: "global", BurstSize: 100, Rate: time.Second},
+	}
+
+	for i := 0; i < 2; i++ {
+		decision, err := limiter.GetDecision(ctx, user1Limits, 1)
+		assert.Nil(t, err)
+		assert.True(t, decision.Allowed)
+		assert.Equal(t, -1, decision.RejectedLevel)
+		assert.Len(t, decision.Levels, 3)
+		// user bucket has the fewest tokens
+		assert.Equal(t, 2, decision.Limit)
+		assert.Equal(t, 1-i, decision.Remaining)
+	}
+
+	// user bucket rejects, subscription and global buckets are not drained
+	decision, err := limiter.GetDecision(ctx, user1Limits, 1)
+	assert.Nil(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, 0, decision.RejectedLevel)
+	rejected, ok := decision.RejectedLimit(user1Limits)
+	assert.True(t, ok)
+	assert.Equal(t, "user1", rejected.Key)
+	assert.True(t, decision.RetryAfter > 30*time.Second)
+	remaining, err := rateLimiter.GetStats(ctx, "subscription1", 3, 30*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, remaining)
+	remaining, err = rateLimiter.GetStats(ctx, "global", 100, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, 98, remaining)
+
+	// subscription bucket rejects another user, largest retry after of all rejecting levels is returned
+	user2Limits := []Limit{
+		{Key: "user2", BurstSize: 10, Rate: time.Second},
+		user1Limits[1],
+		user1Limits[2],
+	}
+	decision, err = limiter.GetDecision(ctx, user2Limits, 2)
+	assert.Nil(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, 1, decision.RejectedLevel)
+	assert.True(t, decision.Levels[0].Allowed)
+	remaining, err = rateLimiter.GetStats(ctx, "user2", 10, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, remaining)
+}
+
+type countingRateLimiter struct {
+	*TokenBucketRateLimiter
+	reserved int
+}
+
+func (r *countingRateLimiter) Reserve(ctx context.Context, key string, burstSize int, rate time.Duration, cost int) (*Reservation, error) {
+	r.reserved++
+	return r.TokenBucketRateLimiter.Reserve(ctx, key, burstSize, rate, cost)
+}
+
+func TestHierarchicalRateLimiterStopsAtRejection(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := &countingRateLimiter{TokenBucketRateLimiter: NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), newTestRedisCacheClient(t))}
+	limiter := NewHierarchicalRateLimiter(rateLimiter)
+	limits := []Limit{
+		{Key: "user1", BurstSize: 1, Rate: time.Second},
+		{Key: "subscription1", BurstSize: 1, Rate: time.Minute},
+		{Key: "global", BurstSize: 100, Rate: time.Second},
+	}
+
+	decision, err := limiter.GetDecision(ctx, limits, 1)
+	assert.Nil(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, 3, rateLimiter.reserved)
+
+	// outer levels are peeked after user bucket rejects, retry after of subscription bucket is returned
+	decision, err = limiter.GetDecision(ctx, limits, 1)
+	assert.Nil(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, 4, rateLimiter.reserved)
+	assert.Equal(t, 0, decision.RejectedLevel)
+	assert.Len(t, decision.Levels, 3)
+	assert.False(t, decision.Levels[1].Allowed)
+	assert.True(t, decision.Levels[2].Allowed)
+	assert.True(t, decision.RetryAfter > 30*time.Second)
+	remaining, err := rateLimiter.GetStats(ctx, "global", 100, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, 99, remaining)
+}
+
+func TestHierarchicalRateLimiterNoLimits(t *testing.T) {
+	limiter := NewHierarchicalRateLimiter(NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), nil))
+
+	decision, err := limiter.GetDecision(context.Background(), nil, 1)
+	assert.NotNil(t, err)
+	assert.True(t, decision.Allowed)
+}
@@ -11,4 +11,7 @@ import (
 
 type RateLimiter interface {
 	GetDecision(ctx context.Context, key string, burstSize int, rate time.Duration) (RateLimiterDecision, error)
+	GetDecisionWithCost(ctx context.Context, key string, burstSize int, rate time.Duration, cost int) (RateLimiterDecision, error)
+	Reserve(ctx context.Context, key string, burstSize int, rate time.Duration, cost int) (*Reservation, error)
+	Wait(ctx context.Context, key string, burstSize int, rate time.Duration, cost int) error
 }
@@ -50,3 +50,47 @@ func (mr *MockRateLimiterMockRecorder) GetDecision(arg0, arg1, arg2, arg3 interf
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDecision", reflect.TypeOf((*MockRateLimiter)(nil).GetDecision), arg0, arg1, arg2, arg3)
 }
+
+// GetDecisionWithCost mocks base method.
+func (m *MockRateLimiter) GetDecisionWithCost(arg0 context.Context, arg1 string, arg2 int, arg3 time.Duration, arg4 int) (ratelimiter.RateLimiterDecision, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDecisionWithCost", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(ratelimiter.RateLimiterDecision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDecisionWithCost indicates an expected call of GetDecisionWithCost.
+func (mr *MockRateLimiterMockRecorder) GetDecisionWithCost(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDecisionWithCost", reflect.TypeOf((*MockRateLimiter)(nil).GetDecisionWithCost), arg0, arg1, arg2, arg3, arg4)
+}
+
+// Reserve mocks base method.
+func (m *MockRateLimiter) Reserve(arg0 context.Context, arg1 string, arg2 int, arg3 time.Duration, arg4 int) (*ratelimiter.Reservation, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Reserve", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(*ratelimiter.Reservation)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Reserve indicates an expected call of Reserve.
+func (mr *MockRateLimiterMockRecorder) Reserve(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reserve", reflect.TypeOf((*MockRateLimiter)(nil).Reserve), arg0, arg1, arg2, arg3, arg4)
+}
+
+// Wait mocks base method.
+func (m *MockRateLimiter) Wait(arg0 context.Context, arg1 string, arg2 int, arg3 time.Duration, arg4 int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Wait", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Wait indicates an expected call of Wait.
+func (mr *MockRateLimiterMockRecorder) Wait(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Wait", reflect.TypeOf((*MockRateLimiter)(nil).Wait), arg0, arg1, arg2, arg3, arg4)
+}
@@ -0,0 +1,106 @@
+package ratelimiter
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// DecisionEvent is a decision passed to observers
+type DecisionEvent struct {
+	Time time.Time
+	Key  string
+	// Policy is empty for decisions made without a policy
+	Policy string
+	Cost   int
+	// Decision is the decision before shadow mode allows the request
+	Decision RateLimiterDecision
+	Err      error
+	// Shadow is set for decisions made in shadow mode
+	Shadow bool
+}
+
+// Observer receives every decision of a rate limiter, including decisions of Wait, it's called before the decision is returned so it must not block
+type Observer interface {
+	ObserveDecision(ctx context.Context, event DecisionEvent)
+}
+
+// ObserverFunc is a function which implements Observer
+type ObserverFunc func(ctx context.Context, event DecisionEvent)
+
+func (f ObserverFunc) ObserveDecision(ctx context.Context, event DecisionEvent) {
+	f(ctx, event)
+}
+
+const DefaultAllowedSampleRate = 0.01
+
+// AuditLogger is an Observer which writes decisions to a structured log
+// rejected decisions and decisions with errors are always logged, allowed decisions are sampled
+type AuditLogger struct {
+	logger            *slog.Logger
+	allowedSampleRate float64
+}
+
+type AuditLoggerOption func(*AuditLogger)
+
+// WithAllowedSampleRate sets the ratio of allowed decisions logged, from 0 to 1, default is 0.01
+func WithAllowedSampleRate(rate float64) AuditLoggerOption {
+	return func(a *AuditLogger) {
+		a.allowedSampleRate = rate
+	}
+}
+
+func NewAuditLogger(logger *slog.Logger, opts ...AuditLoggerOption) *AuditLogger {
+	a := &AuditLogger{
+		logger:            logger,
+		allowedSampleRate: DefaultAllowedSampleRate,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+func (a *AuditLogger) ObserveDecision(ctx context.Context, event DecisionEvent) {
+	level, msg := slog.LevelInfo, "rate limiter allowed request"
+	switch {
+	case event.Err != nil:
+		level, msg = slog.LevelWarn, "rate limiter decision failed"
+	case !event.Decision.Allowed && event.Shadow:
+		level, msg = slog.LevelWarn, "rate limiter would reject request in shadow mode"
+	case !event.Decision.Allowed && event.Decision.Override == OverrideDeny:
+		level, msg = slog.LevelWarn, "rate limiter rejected request by override"
+	case !event.Decision.Allowed:
+		level, msg = slog.LevelWarn, "rate limiter rejected request"
+	case rand.Float64() >= a.allowedSampleRate:
+		return
+	}
+	if !a.logger.Enabled(ctx, level) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.Time("decision_time", event.Time),
+		slog.String("key", event.Key),
+		slog.String("policy", event.Policy),
+		slog.Int("cost", event.Cost),
+		slog.Bool("allowed", event.Decision.Allowed),
+		slog.Int("limit", event.Decision.Limit),
+		slog.Int("remaining", event.Decision.Remaining),
+		slog.Duration("retry_after", event.Decision.RetryAfter),
+		slog.String("backend", string(event.Decision.Backend)),
+		slog.Bool("degraded", event.Decision.Degraded),
+		slog.Bool("shadow", event.Shadow),
+	}
+	if event.Decision.Override != "" {
+		attrs = append(attrs, slog.String("override", string(event.Decision.Override)), slog.String("override_reason", event.Decision.OverrideReason))
+	}
+	if event.Decision.Allowed && event.Err == nil {
+		// count of allowed decisions is count of logs divided by sample rate
+		attrs = append(attrs, slog.Float64("sample_rate", a.allowedSampleRate))
+	}
+	if event.Err != nil {
+		attrs = append(attrs, slog.String("error", event.Err.Error()))
+	}
+	a.logger.LogAttrs(ctx, level, msg, attrs...)
+}
@@ -0,0 +1,102 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Azure/rate-limiter/pkg/cache"
+)
+
+func TestObserver(t *testing.T) {
+	ctx := context.Background()
+	var events []DecisionEvent
+	observer := ObserverFunc(func(ctx context.Context, event DecisionEvent) { events = append(events, event) })
+	limiter := newTestPolicyRateLimiter(t, nil, WithPolicyObserver(observer))
+
+	_, err := limiter.GetDecisionForPolicy(ctx, "create", "billingAccount:id1")
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+	assert.Len(t, events, 1)
+	assert.Equal(t, "billingAccount:id1", events[0].Key)
+	assert.Equal(t, "create", events[0].Policy)
+	assert.Equal(t, 2, events[0].Cost)
+	assert.True(t, events[0].Decision.Allowed)
+	assert.Equal(t, BackendMemory, events[0].Decision.Backend)
+	assert.ErrorIs(t, events[0].Err, ErrBackendUnavailable)
+
+	rateLimiter := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), newTestRedisCacheClient(t), WithObserver(observer))
+	_, err = rateLimiter.GetDecisionWithCost(ctx, "id1", 2, time.Minute, 3)
+	assert.NotNil(t, err)
+	assert.Len(t, events, 2)
+	assert.Equal(t, "id1", events[1].Key)
+	assert.Equal(t, "", events[1].Policy)
+	assert.False(t, events[1].Decision.Allowed)
+
+	// decisions of wait are observed too
+	assert.Nil(t, rateLimiter.Wait(ctx, "id2", 2, time.Minute, 1))
+	assert.Len(t, events, 3)
+	assert.Equal(t, "id2", events[2].Key)
+	assert.True(t, events[2].Decision.Allowed)
+	assert.Equal(t, 1, events[2].Decision.Remaining)
+}
+
+func TestAuditLogger(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	rateLimiter := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), newTestRedisCacheClient(t),
+		WithObserver(NewAuditLogger(logger, WithAllowedSampleRate(0))))
+
+	decision, err := rateLimiter.GetDecision(ctx, "customerX", 1, time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, decision.Allowed)
+	// allowed decisions aren't sampled
+	assert.Equal(t, 0, buf.Len())
+
+	decision, err = rateLimiter.GetDecision(ctx, "customerX", 1, time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, decision.Allowed)
+	var entry map[string]any
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "WARN", entry["level"])
+	assert.Equal(t, "rate limiter rejected request", entry["msg"])
+	assert.Equal(t, "customerX", entry["key"])
+	assert.Equal(t, false, entry["allowed"])
+	assert.Equal(t, "remote", entry["backend"])
+
+	buf.Reset()
+	rateLimiter = NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), newTestRedisCacheClient(t),
+		WithObserver(NewAuditLogger(logger, WithAllowedSampleRate(1))))
+	for i := 0; i < 2; i++ {
+		_, err = rateLimiter.GetDecision(ctx, "customerY", 2, time.Minute)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 2, strings.Count(buf.String(), `"sample_rate":1`))
+}
+
+func TestAuditLoggerOverride(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	remoteClient := newTestRedisCacheClient(t)
+	store := NewOverrideStore(remoteClient)
+	rateLimiter := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), remoteClient,
+		WithOverrides(store), WithObserver(NewAuditLogger(logger)))
+	assert.Nil(t, store.Set(ctx, "customerX", Override{Type: OverrideDeny, Reason: "incident 1"}, time.Hour))
+
+	decision, err := rateLimiter.GetDecision(ctx, "customerX", 10, time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, OverrideDeny, decision.Override)
+	var entry map[string]any
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "rate limiter rejected request by override", entry["msg"])
+	assert.Equal(t, "deny", entry["override"])
+	assert.Equal(t, "incident 1", entry["override_reason"])
+}
@@ -0,0 +1,207 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Azure/rate-limiter/pkg/cache"
+)
+
+const (
+	overrideKeyPrefix = "override:"
+	overrideTypeKey   = "type"
+	overrideBurstKey  = "burstSize"
+	overrideRateKey   = "rate"
+	overrideExpireKey = "expiresAt"
+	overrideReasonKey = "reason"
+
+	// overrides without expire time are kept in cache for a year, cache clients can't keep a key forever
+	noOverrideExpireTime = 365 * 24 * time.Hour
+	// DenyRetryAfter is the retry after time of requests denied by an override without expire time
+	DenyRetryAfter = time.Minute
+)
+
+// OverrideType decides how an overridden key is limited
+type OverrideType string
+
+const (
+	// OverrideAllow always allows requests of the key
+	OverrideAllow OverrideType = "allow"
+	// OverrideDeny always rejects requests of the key
+	OverrideDeny OverrideType = "deny"
+	// OverrideLimit limits requests of the key by BurstSize and Rate of the override instead of the default
+	OverrideLimit OverrideType = "limit"
+)
+
+type Override struct {
+	Type      OverrideType
+	BurstSize int
+	Rate      time.Duration
+	// ExpiresAt is when the override is removed, zero for no expire time
+	ExpiresAt time.Time
+	// Reason is kept for auditing, e.g. the incident the override is added for
+	Reason string
+}
+
+// OverrideStore keeps per key overrides in remote cache, so they can be changed for all replicas without changing config
+// overrides are cached locally for a short time to save a round trip per request, a change applies after local ttl
+type OverrideStore struct {
+	remoteCacheClient cache.CacheClient
+	localCacheClient  cache.CacheClient
+	localTTL          time.Duration
+}
+
+type OverrideOption func(*OverrideStore)
+
+// WithOverrideLocalTTL sets how long overrides are cached locally, default is 5s
+func WithOverrideLocalTTL(ttl time.Duration) OverrideOption {
+	return func(s *OverrideStore) {
+		s.localTTL = ttl
+	}
+}
+
+func NewOverrideStore(remoteCacheClient cache.CacheClient, opts ...OverrideOption) *OverrideStore {
+	s := &OverrideStore{
+		remoteCacheClient: remoteCacheClient,
+		localTTL:          5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.localCacheClient = cache.NewMemCacheClient(s.localTTL, 2*s.localTTL)
+	return s
+}
+
+// Set adds or replaces the override of key, it's removed after expireTime, 0 for no expire time
+func (s *OverrideStore) Set(ctx context.Context, key string, override Override, expireTime time.Duration) error {
+	switch override.Type {
+	case OverrideAllow, OverrideDeny:
+	case OverrideLimit:
+		if override.BurstSize <= 0 || override.Rate <= 0 {
+			return errors.New("burst size and rate of limit override must be greater than 0")
+		}
+	default:
+		return fmt.Errorf("invalid override type %q", override.Type)
+	}
+	override.ExpiresAt = time.Time{}
+	if expireTime > 0 {
+		override.ExpiresAt = time.Now().Add(expireTime)
+	} else {
+		expireTime = noOverrideExpireTime
+	}
+	cacheData := map[string]string{
+		overrideTypeKey:   string(override.Type),
+		overrideBurstKey:  strconv.Itoa(override.BurstSize),
+		overrideRateKey:   strconv.FormatInt(int64(override.Rate), 10),
+		overrideExpireKey: strconv.FormatInt(override.ExpiresAt.UnixMilli(), 10),
+		overrideReasonKey: override.Reason,
+	}
+	if override.ExpiresAt.IsZero() {
+		cacheData[overrideExpireKey] = "0"
+	}
+	return s.remoteCacheClient.UpdateCache(ctx, overrideKeyPrefix+key, cacheData, expireTime)
+}
+
+// Delete removes the override of key
+func (s *OverrideStore) Delete(ctx context.Context, key string) error {
+	// cache clients can't delete a key, an override without type is no override
+	return s.remoteCacheClient.UpdateCache(ctx, overrideKeyPrefix+key, map[string]string{overrideTypeKey: ""}, time.Second)
+}
+
+// Get returns the override of key, false if there is none
+// a failed read matches ErrBackendUnavailable, the key is treated as without override until local ttl passes,
+// so requests don't pay an extra round trip to a failing remote cache
+func (s *OverrideStore) Get(ctx context.Context, key string) (Override, bool, error) {
+	currentCache, _ := s.localCacheClient.GetCache(ctx, overrideKeyPrefix+key)
+	var err error
+	if currentCache == nil {
+		if currentCache, err = s.remoteCacheClient.GetCache(ctx, overrideKeyPrefix+key); err != nil {
+			currentCache = nil
+			err = backendError(err)
+		}
+		if currentCache == nil {
+			// keys without override and failed reads are cached too
+			currentCache = map[string]string{}
+		}
+		// memcache won't return any error
+		_ = s.localCacheClient.UpdateCache(ctx, overrideKeyPrefix+key, currentCache, s.localTTL)
+	}
+	if err != nil {
+		return Override{}, false, err
+	}
+	override, found, err := parseOverride(currentCache)
+	if err != nil {
+		return Override{}, false, fmt.Errorf("%w: %w", ErrCorruptState, err)
+	}
+	return override, found, nil
+}
+
+func parseOverride(currentCache map[string]string) (Override, bool, error) {
+	overrideType := OverrideType(currentCache[overrideTypeKey])
+	if overrideType == "" {
+		return Override{}, false, nil
+	}
+	burstSize, err := strconv.Atoi(currentCache[overrideBurstKey])
+	if err != nil {
+		return Override{}, false, err
+	}
+	rate, err := strconv.ParseInt(currentCache[overrideRateKey], 10, 64)
+	if err != nil {
+		return Override{}, false, err
+	}
+	expiresAt, err := strconv.ParseInt(currentCache[overrideExpireKey], 10, 64)
+	if err != nil {
+		return Override{}, false, err
+	}
+	override := Override{
+		Type:      overrideType,
+		BurstSize: burstSize,
+		Rate:      time.Duration(rate),
+		Reason:    currentCache[overrideReasonKey],
+	}
+	if expiresAt > 0 {
+		override.ExpiresAt = time.UnixMilli(expiresAt)
+		if !override.ExpiresAt.After(time.Now()) {
+			return Override{}, false, nil
+		}
+	}
+	return override, true, nil
+}
+
+// applyOverride returns the decision of an allow or deny override, or burst size and rate of a limit override
+// the default burst size and rate are used when overrides can't be read
+func applyOverride(ctx context.Context, store *OverrideStore, key string, burstSize int, rate time.Duration) (*RateLimiterDecision, int, time.Duration, error) {
+	if store == nil {
+		return nil, burstSize, rate, nil
+	}
+	override, found, err := store.Get(ctx, key)
+	if err != nil || !found {
+		return nil, burstSize, rate, err
+	}
+	switch override.Type {
+	case OverrideAllow:
+		return &RateLimiterDecision{Allowed: true, Override: override.Type, OverrideReason: override.Reason}, burstSize, rate, nil
+	case OverrideDeny:
+		retryAfter := DenyRetryAfter
+		if !override.ExpiresAt.IsZero() {
+			retryAfter = time.Until(override.ExpiresAt)
+		}
+		return &RateLimiterDecision{Allowed: false, RetryAfter: retryAfter, Override: override.Type, OverrideReason: override.Reason}, burstSize, rate, nil
+	case OverrideLimit:
+		return nil, override.BurstSize, override.Rate, nil
+	default:
+		return nil, burstSize, rate, fmt.Errorf("invalid override type %q", override.Type)
+	}
+}
+
+// withOverrideError reports a failed override read in the decision made with the default limit
+func withOverrideError(decision RateLimiterDecision, err error, overrideErr error) (RateLimiterDecision, error) {
+	if overrideErr == nil {
+		return decision, err
+	}
+	decision.Degraded = true
+	return decision, errors.Join(err, overrideErr)
+}
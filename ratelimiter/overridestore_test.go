@@ -0,0 +1,149 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Azure/rate-limiter/pkg/algorithm"
+	"github.com/Azure/rate-limiter/pkg/cache"
+)
+
+func TestOverrideStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewOverrideStore(newTestRedisCacheClient(t), WithOverrideLocalTTL(50*time.Millisecond))
+
+	_, found, err := store.Get(ctx, "id1")
+	assert.Nil(t, err)
+	assert.False(t, found)
+
+	assert.Nil(t, store.Set(ctx, "id1", Override{Type: OverrideLimit, BurstSize: 100, Rate: time.Second, Reason: "incident 1"}, 0))
+	// missing override is cached locally
+	_, found, err = store.Get(ctx, "id1")
+	assert.Nil(t, err)
+	assert.False(t, found)
+	time.Sleep(60 * time.Millisecond)
+	override, found, err := store.Get(ctx, "id1")
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Equal(t, Override{Type: OverrideLimit, BurstSize: 100, Rate: time.Second, Reason: "incident 1"}, override)
+
+	assert.Nil(t, store.Delete(ctx, "id1"))
+	time.Sleep(60 * time.Millisecond)
+	_, found, err = store.Get(ctx, "id1")
+	assert.Nil(t, err)
+	assert.False(t, found)
+
+	assert.NotNil(t, store.Set(ctx, "id1", Override{Type: OverrideLimit}, 0))
+	assert.NotNil(t, store.Set(ctx, "id1", Override{Type: "unknown"}, 0))
+}
+
+func TestOverrideStoreExpired(t *testing.T) {
+	ctx := context.Background()
+	store := NewOverrideStore(cache.NewMemCacheClient(time.Minute, time.Minute))
+
+	assert.Nil(t, store.Set(ctx, "id1", Override{Type: OverrideDeny}, 50*time.Millisecond))
+	override, found, err := store.Get(ctx, "id1")
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.WithinDuration(t, time.Now().Add(50*time.Millisecond), override.ExpiresAt, 10*time.Millisecond)
+
+	// expired while cached locally
+	time.Sleep(60 * time.Millisecond)
+	_, found, err = store.Get(ctx, "id1")
+	assert.Nil(t, err)
+	assert.False(t, found)
+}
+
+type failingCacheClient struct {
+	calls int
+}
+
+func (c *failingCacheClient) UpdateCache(ctx context.Context, key string, cacheData map[string]string, expireTime time.Duration) error {
+	c.calls++
+	return errors.New("connection refused")
+}
+
+func (c *failingCacheClient) GetCache(ctx context.Context, key string) (map[string]string, error) {
+	c.calls++
+	return nil, errors.New("connection refused")
+}
+
+func TestOverrideStoreReadFailure(t *testing.T) {
+	ctx := context.Background()
+	remoteClient := &failingCacheClient{}
+	store := NewOverrideStore(remoteClient, WithOverrideLocalTTL(50*time.Millisecond))
+	limiter := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), cache.NewMemCacheClient(time.Minute, time.Minute), WithOverrides(store))
+
+	// the default limit is used, the decision is degraded
+	decision, err := limiter.GetDecision(ctx, "id1", 2, time.Minute)
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+	assert.True(t, decision.Allowed)
+	assert.True(t, decision.Degraded)
+	assert.Equal(t, 2, decision.Limit)
+	assert.Equal(t, 1, remoteClient.calls)
+
+	// the failure is cached locally, remote cache isn't called again until local ttl passes
+	_, found, err := store.Get(ctx, "id1")
+	assert.Nil(t, err)
+	assert.False(t, found)
+	assert.Equal(t, 1, remoteClient.calls)
+	time.Sleep(60 * time.Millisecond)
+	_, _, err = store.Get(ctx, "id1")
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+	assert.Equal(t, 2, remoteClient.calls)
+}
+
+func TestGetDecisionWithOverrides(t *testing.T) {
+	ctx := context.Background()
+	remoteClient := newTestRedisCacheClient(t)
+	store := NewOverrideStore(remoteClient)
+	limiter := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), remoteClient, WithOverrides(store))
+	assert.Nil(t, store.Set(ctx, "internal", Override{Type: OverrideAllow}, 0))
+	assert.Nil(t, store.Set(ctx, "abuser", Override{Type: OverrideDeny}, time.Hour))
+	assert.Nil(t, store.Set(ctx, "premium", Override{Type: OverrideLimit, BurstSize: 5, Rate: time.Minute}, 0))
+
+	for i := 0; i < 3; i++ {
+		decision, err := limiter.GetDecision(ctx, "internal", 1, time.Minute)
+		assert.Nil(t, err)
+		assert.True(t, decision.Allowed)
+	}
+
+	decision, err := limiter.GetDecision(ctx, "abuser", 1, time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, decision.Allowed)
+	assert.InDelta(t, float64(time.Hour), float64(decision.RetryAfter), float64(time.Second))
+	assert.ErrorIs(t, limiter.Wait(ctx, "abuser", 1, time.Minute, 1), ErrDeniedByOverride)
+
+	decision, err = limiter.GetDecision(ctx, "premium", 1, time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, 5, decision.Limit)
+
+	// other keys use the default limit
+	decision, err = limiter.GetDecision(ctx, "id1", 1, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, decision.Limit)
+}
+
+func TestGetDecisionForPolicyWithOverrides(t *testing.T) {
+	ctx := context.Background()
+	remoteClient := newTestRedisCacheClient(t)
+	store := NewOverrideStore(remoteClient)
+	limiter := newTestPolicyRateLimiter(t, remoteClient, WithPolicyOverrides(store))
+	assert.Nil(t, store.Set(ctx, "billingAccount:id1", Override{Type: OverrideLimit, BurstSize: 10, Rate: time.Second}, 0))
+
+	decision, err := limiter.GetDecisionForPolicy(ctx, "create", "billingAccount:id1")
+	assert.Nil(t, err)
+	assert.Equal(t, 10, decision.Limit)
+	assert.Equal(t, 8, decision.Remaining)
+
+	// policy cost isn't lowered to a smaller burst size of the override
+	assert.Nil(t, store.Set(ctx, "billingAccount:id2", Override{Type: OverrideLimit, BurstSize: 1, Rate: time.Second}, 0))
+	decision, err = limiter.GetDecisionForPolicy(ctx, "create", "billingAccount:id2")
+	assert.ErrorIs(t, err, algorithm.ErrCostExceedsBurstSize)
+	assert.False(t, decision.Allowed)
+}
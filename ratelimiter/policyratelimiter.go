@@ -0,0 +1,136 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/Azure/rate-limiter/pkg/cache"
+	"github.com/Azure/rate-limiter/pkg/metrics"
+	"github.com/Azure/rate-limiter/pkg/policy"
+)
+
+// PolicyRateLimiter makes decisions by named policies of a registry instead of burst size and rate passed by every call
+// every policy has its own buckets, keys are prefixed by policy name
+// policies are read from provider on every decision, so reloaded policies apply at once
+type PolicyRateLimiter struct {
+	provider          policy.Provider
+	memCacheClient    cache.CacheClient
+	remoteCacheClient cache.CacheClient
+	overrides         *OverrideStore
+	metrics           *metrics.Metrics
+	observers         []Observer
+
+	// limiters are built once for every loaded registry
+	limiters atomic.Pointer[policyLimiters]
+}
+
+// policyLimiters are the rate limiters of policies of registry
+type policyLimiters struct {
+	registry     *policy.Registry
+	rateLimiters map[*policy.Policy]*TokenBucketRateLimiter
+}
+
+type PolicyOption func(*PolicyRateLimiter)
+
+// WithPolicyOverrides consults per key overrides in store before the policy, keys are not prefixed by policy name for overrides
+func WithPolicyOverrides(store *OverrideStore) PolicyOption {
+	return func(r *PolicyRateLimiter) {
+		r.overrides = store
+	}
+}
+
+// WithPolicyMetrics exports metrics of decisions labeled by policy name, see WithMetrics
+func WithPolicyMetrics(m *metrics.Metrics) PolicyOption {
+	return func(r *PolicyRateLimiter) {
+		r.metrics = m
+	}
+}
+
+// WithPolicyObserver passes every decision to observer with the policy name, keys are not prefixed by policy name in events
+func WithPolicyObserver(observer Observer) PolicyOption {
+	return func(r *PolicyRateLimiter) {
+		r.observers = append(r.observers, observer)
+	}
+}
+
+func NewPolicyRateLimiter(provider policy.Provider, memCacheClient, remoteCacheClient cache.CacheClient, opts ...PolicyOption) *PolicyRateLimiter {
+	r := &PolicyRateLimiter{
+		provider:          provider,
+		memCacheClient:    memCacheClient,
+		remoteCacheClient: remoteCacheClient,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// GetDecisionForPolicy takes the cost of policy from the bucket of key, the request is allowed with an error when policy is not found
+func (r *PolicyRateLimiter) GetDecisionForPolicy(ctx context.Context, policyName string, key string) (RateLimiterDecision, error) {
+	p, found := r.provider.Registry().Get(policyName)
+	if !found {
+		// wrong config, fail open
+		return RateLimiterDecision{Allowed: true, Degraded: true}, fmt.Errorf("%w: policy %s not found", ErrInvalidConfig, policyName)
+	}
+	return r.getDecision(ctx, p, key)
+}
+
+// GetDecisionForRequest uses the first policy matching key and request attributes, the request is allowed when no policy matches
+// all matching shadow policies are evaluated too, their decisions are only passed to observers and metrics
+func (r *PolicyRateLimiter) GetDecisionForRequest(ctx context.Context, key string, attributes map[string]string) (RateLimiterDecision, error) {
+	registry := r.provider.Registry()
+	for _, p := range registry.MatchShadow(key, attributes) {
+		// shadow decisions and errors are reported to observers
+		_, _ = r.getDecision(ctx, p, key)
+	}
+	p, found := registry.Match(key, attributes)
+	if !found {
+		return RateLimiterDecision{Allowed: true}, nil
+	}
+	return r.getDecision(ctx, p, key)
+}
+
+func (r *PolicyRateLimiter) getDecision(ctx context.Context, p *policy.Policy, key string) (RateLimiterDecision, error) {
+	rateLimiter := r.rateLimiter(p)
+	overridden, burstSize, rate, overrideErr := applyOverride(ctx, r.overrides, key, p.BurstSize, p.Rate)
+	if overridden != nil {
+		rateLimiter.observe(ctx, p.Name+":"+key, p.Cost, *overridden, nil)
+		return rateLimiter.shadowDecision(*overridden), nil
+	}
+	// a limit override with a burst size smaller than the policy cost is rejected with algorithm.ErrCostExceedsBurstSize
+	decision, err := rateLimiter.GetDecisionWithCost(ctx, p.Name+":"+key, burstSize, rate, p.Cost)
+	return withOverrideError(decision, err, overrideErr)
+}
+
+// rateLimiter returns the rate limiter of p, rate limiters are built again when the registry is reloaded
+func (r *PolicyRateLimiter) rateLimiter(p *policy.Policy) *TokenBucketRateLimiter {
+	registry := r.provider.Registry()
+	limiters := r.limiters.Load()
+	if limiters == nil || limiters.registry != registry {
+		limiters = &policyLimiters{registry: registry, rateLimiters: make(map[*policy.Policy]*TokenBucketRateLimiter, len(registry.Policies()))}
+		for _, policy := range registry.Policies() {
+			limiters.rateLimiters[policy] = r.newRateLimiter(policy)
+		}
+		r.limiters.Store(limiters)
+	}
+	if rateLimiter, found := limiters.rateLimiters[p]; found {
+		return rateLimiter
+	}
+	// p is of a registry replaced after it was read
+	return r.newRateLimiter(p)
+}
+
+func (r *PolicyRateLimiter) newRateLimiter(p *policy.Policy) *TokenBucketRateLimiter {
+	opts := []Option{WithAlgorithm(p.Factory()), WithFailMode(p.FailMode), withPolicyName(p.Name)}
+	if r.metrics != nil {
+		opts = append(opts, WithMetrics(r.metrics))
+	}
+	for _, observer := range r.observers {
+		opts = append(opts, WithObserver(observer))
+	}
+	if p.Shadow {
+		opts = append(opts, WithShadowMode())
+	}
+	return NewTokenBucketRateLimiter(r.memCacheClient, r.remoteCacheClient, opts...)
+}
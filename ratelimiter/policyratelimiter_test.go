@@ -0,0 +1,168 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Azure/rate-limiter/pkg/cache"
+	"github.com/Azure/rate-limiter/pkg/policy"
+)
+
+func newTestPolicyRateLimiter(t *testing.T, remoteCacheClient cache.CacheClient, opts ...PolicyOption) *PolicyRateLimiter {
+	registry, err := policy.NewRegistry(
+		policy.Policy{Name: "create", KeyPattern: "billingAccount:*", Attributes: map[string]string{"method": "POST"}, BurstSize: 4, Rate: time.Minute, Cost: 2},
+		policy.Policy{Name: "strict", Algorithm: "gcra", BurstSize: 1, Rate: time.Minute, FailMode: policy.FailClosed},
+	)
+	assert.Nil(t, err)
+	return NewPolicyRateLimiter(registry, cache.NewMemCacheClient(time.Minute, time.Minute), remoteCacheClient, opts...)
+}
+
+func TestGetDecisionForPolicy(t *testing.T) {
+	ctx := context.Background()
+	limiter := newTestPolicyRateLimiter(t, newTestRedisCacheClient(t))
+
+	for i := 0; i < 2; i++ {
+		decision, err := limiter.GetDecisionForPolicy(ctx, "create", "billingAccount:id1")
+		assert.Nil(t, err)
+		assert.True(t, decision.Allowed)
+		assert.Equal(t, 2-2*i, decision.Remaining)
+	}
+	decision, err := limiter.GetDecisionForPolicy(ctx, "create", "billingAccount:id1")
+	assert.Nil(t, err)
+	assert.False(t, decision.Allowed)
+
+	// policies have their own buckets
+	decision, err = limiter.GetDecisionForPolicy(ctx, "strict", "billingAccount:id1")
+	assert.Nil(t, err)
+	assert.True(t, decision.Allowed)
+
+	decision, err = limiter.GetDecisionForPolicy(ctx, "unknown", "billingAccount:id1")
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.True(t, decision.Allowed)
+}
+
+func TestGetDecisionForRequest(t *testing.T) {
+	ctx := context.Background()
+	limiter := newTestPolicyRateLimiter(t, newTestRedisCacheClient(t))
+
+	decision, err := limiter.GetDecisionForRequest(ctx, "billingAccount:id1", map[string]string{"method": "POST"})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, decision.Limit)
+
+	decision, err = limiter.GetDecisionForRequest(ctx, "billingAccount:id1", map[string]string{"method": "GET"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, decision.Limit)
+}
+
+func TestGetDecisionForPolicyFailMode(t *testing.T) {
+	ctx := context.Background()
+	// remote cache is missing
+	limiter := newTestPolicyRateLimiter(t, nil)
+
+	decision, err := limiter.GetDecisionForPolicy(ctx, "create", "billingAccount:id1")
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+	assert.True(t, decision.Allowed)
+	assert.True(t, decision.Degraded)
+
+	decision, err = limiter.GetDecisionForPolicy(ctx, "strict", "billingAccount:id1")
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+	assert.False(t, decision.Allowed)
+	assert.True(t, decision.Degraded)
+	assert.Equal(t, time.Minute, decision.RetryAfter)
+}
+
+func TestGetDecisionForPolicyShadow(t *testing.T) {
+	ctx := context.Background()
+	registry, err := policy.NewRegistry(
+		policy.Policy{Name: "create", BurstSize: 1, Rate: time.Minute},
+		policy.Policy{Name: "create-v2", BurstSize: 1, Rate: time.Minute, Shadow: true},
+	)
+	assert.Nil(t, err)
+	var events []DecisionEvent
+	observer := ObserverFunc(func(ctx context.Context, event DecisionEvent) { events = append(events, event) })
+	remoteCacheClient := newTestRedisCacheClient(t)
+	limiter := NewPolicyRateLimiter(registry, cache.NewMemCacheClient(time.Minute, time.Minute), remoteCacheClient, WithPolicyObserver(observer))
+
+	for i := 0; i < 2; i++ {
+		decision, err := limiter.GetDecisionForPolicy(ctx, "create-v2", "billingAccount:id1")
+		assert.Nil(t, err)
+		assert.True(t, decision.Allowed)
+		assert.True(t, decision.Shadow)
+		assert.Equal(t, i == 1, decision.ShadowRejected)
+		assert.Equal(t, time.Duration(0), decision.RetryAfter)
+	}
+	assert.Len(t, events, 2)
+	assert.True(t, events[1].Shadow)
+	assert.False(t, events[1].Decision.Allowed)
+	assert.Equal(t, "billingAccount:id1", events[1].Key)
+
+	// shadow buckets are separate
+	tokens, err := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), remoteCacheClient).
+		GetStats(ctx, "shadow:create-v2:billingAccount:id1", 1, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, tokens)
+	decision, err := limiter.GetDecisionForPolicy(ctx, "create", "billingAccount:id1")
+	assert.Nil(t, err)
+	assert.True(t, decision.Allowed)
+	assert.False(t, decision.Shadow)
+}
+
+func TestGetDecisionForRequestShadow(t *testing.T) {
+	ctx := context.Background()
+	// the shadow policy is listed first, it must not switch off the enforced one
+	registry, err := policy.NewRegistry(
+		policy.Policy{Name: "create-v2", KeyPattern: "billingAccount:*", BurstSize: 1, Rate: time.Minute, Shadow: true},
+		policy.Policy{Name: "create", KeyPattern: "billingAccount:*", BurstSize: 2, Rate: time.Minute},
+	)
+	assert.Nil(t, err)
+	var events []DecisionEvent
+	observer := ObserverFunc(func(ctx context.Context, event DecisionEvent) { events = append(events, event) })
+	limiter := NewPolicyRateLimiter(registry, cache.NewMemCacheClient(time.Minute, time.Minute), newTestRedisCacheClient(t), WithPolicyObserver(observer))
+
+	for i := 0; i < 3; i++ {
+		decision, err := limiter.GetDecisionForRequest(ctx, "billingAccount:id1", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, i < 2, decision.Allowed)
+		assert.False(t, decision.Shadow)
+	}
+	// both policies are evaluated for every request
+	assert.Len(t, events, 6)
+	assert.Equal(t, "create-v2", events[2].Policy)
+	assert.True(t, events[2].Shadow)
+	assert.False(t, events[2].Decision.Allowed)
+	assert.Equal(t, "create", events[3].Policy)
+	assert.True(t, events[3].Decision.Allowed)
+}
+
+type testProvider struct {
+	registry *policy.Registry
+}
+
+func (p *testProvider) Registry() *policy.Registry {
+	return p.registry
+}
+
+func TestPolicyRateLimiterReload(t *testing.T) {
+	ctx := context.Background()
+	registry, err := policy.NewRegistry(policy.Policy{Name: "create", BurstSize: 1, Rate: time.Minute})
+	assert.Nil(t, err)
+	provider := &testProvider{registry: registry}
+	limiter := NewPolicyRateLimiter(provider, cache.NewMemCacheClient(time.Minute, time.Minute), newTestRedisCacheClient(t))
+
+	// rate limiters are built once per registry
+	p, _ := registry.Get("create")
+	rateLimiter := limiter.rateLimiter(p)
+	assert.Same(t, rateLimiter, limiter.rateLimiter(p))
+
+	provider.registry, err = policy.NewRegistry(policy.Policy{Name: "create", Algorithm: "gcra", BurstSize: 1, Rate: time.Minute, FailMode: policy.FailClosed})
+	assert.Nil(t, err)
+	reloaded, _ := provider.registry.Get("create")
+	assert.NotSame(t, rateLimiter, limiter.rateLimiter(reloaded))
+	assert.Equal(t, policy.FailClosed, limiter.rateLimiter(reloaded).failMode)
+	decision, err := limiter.GetDecisionForPolicy(ctx, "create", "id1")
+	assert.Nil(t, err)
+	assert.True(t, decision.Allowed)
+}
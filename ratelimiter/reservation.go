@@ -0,0 +1,112 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Azure/rate-limiter/pkg/algorithm"
+	"github.com/Azure/rate-limiter/pkg/cache"
+)
+
+// Reservation is the decision made by Reserve, tokens taken by it can be given back when the request should not count,
+// e.g. the request turns out to be invalid
+type Reservation struct {
+	Decision RateLimiterDecision
+
+	mu sync.Mutex
+	// refundable is the number of tokens taken and not refunded yet
+	refundable int
+	refund     func(ctx context.Context, cost int) error
+}
+
+// Cancel gives back all tokens not refunded yet
+func (r *Reservation) Cancel(ctx context.Context) error {
+	return r.Refund(ctx, math.MaxInt)
+}
+
+// Refund gives back cost tokens to both memcache and remote cache, a bucket never has more than burst size tokens after refunding
+// tokens are refunded at most once, nothing is refunded when the request is not allowed or the rate limiter failed open
+func (r *Reservation) Refund(ctx context.Context, cost int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cost = min(cost, r.refundable)
+	if cost <= 0 || r.refund == nil {
+		return nil
+	}
+	r.refundable -= cost
+	return r.refund(ctx, cost)
+}
+
+// Reserve takes cost tokens like GetDecisionWithCost, and returns a reservation to give them back later
+func (r *TokenBucketRateLimiter) Reserve(ctx context.Context, key string, burstSize int, rate time.Duration, cost int) (*Reservation, error) {
+	ctx, span := r.startSpan(ctx, "TokenBucketRateLimiter.Reserve", cost)
+	overridden, burstSize, rate, overrideErr := applyOverride(ctx, r.overrides, key, burstSize, rate)
+	if overridden != nil {
+		// nothing is taken for allow and deny overrides
+		r.observe(ctx, key, cost, *overridden, nil)
+		endDecisionSpan(span, *overridden, nil)
+		return &Reservation{Decision: r.shadowDecision(*overridden)}, nil
+	}
+	bucketKey := r.bucketKey(key)
+	decision, err := r.getDecision(ctx, bucketKey, burstSize, rate, cost)
+	decision, err = withOverrideError(decision, err, overrideErr)
+	r.observe(ctx, key, cost, decision, err)
+	endDecisionSpan(span, decision, err)
+	reservation := &Reservation{Decision: r.shadowDecision(decision)}
+	// backend isn't set when nothing is taken because of wrong config or cost
+	if !decision.Allowed || decision.Backend == "" {
+		return reservation, err
+	}
+	reservation.refundable = cost
+	reservation.refund = func(ctx context.Context, cost int) error {
+		return r.refund(ctx, bucketKey, burstSize, rate, cost)
+	}
+	return reservation, err
+}
+
+func (r *TokenBucketRateLimiter) refund(ctx context.Context, key string, burstSize int, rate time.Duration, cost int) error {
+	algo, err := r.newAlgorithm(burstSize, rate)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	err1 := refundTokensToCache(ctx, r.remoteCacheClient, algo, key, cost)
+	err2 := refundTokensToCache(ctx, r.memCacheClient, algo, key, cost)
+	return errors.Join(err1, err2)
+}
+
+func refundTokensToCache(ctx context.Context, client cache.CacheClient, algo algorithm.Algorithm, key string, cost int) error {
+	if client == nil {
+		return fmt.Errorf("%w: cache client is nil", ErrBackendUnavailable)
+	}
+	if bucket, ok := algo.(*algorithm.Bucket); ok {
+		if refundClient, ok := client.(cache.TokenBucketRefundCacheClient); ok {
+			if _, _, _, err := refundClient.RefundTokens(ctx, key, bucket.BurstSize, bucket.TokenDropRate, cost); err != nil {
+				return backendError(err)
+			}
+			return nil
+		}
+	}
+	refunder, ok := algo.(algorithm.Refunder)
+	if !ok {
+		return fmt.Errorf("%w: algorithm doesn't support refund", ErrInvalidConfig)
+	}
+	currentCache, err := client.GetCache(ctx, key)
+	if err != nil {
+		return backendError(err)
+	}
+	result, err := refunder.Refund(currentCache, cost)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCorruptState, err)
+	}
+	if result.CacheData == nil {
+		return nil
+	}
+	if err = client.UpdateCache(ctx, key, result.CacheData, result.ExpireTime); err != nil {
+		return backendError(err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Azure/rate-limiter/pkg/algorithm"
+	"github.com/Azure/rate-limiter/pkg/cache"
+)
+
+func TestReserveCancel(t *testing.T) {
+	ctx := context.Background()
+	memClient := cache.NewMemCacheClient(time.Minute, time.Minute)
+	limiter := NewTokenBucketRateLimiter(memClient, newTestRedisCacheClient(t))
+
+	reservation, err := limiter.Reserve(ctx, "id1", 5, time.Minute, 3)
+	assert.Nil(t, err)
+	assert.True(t, reservation.Decision.Allowed)
+	assert.Equal(t, 2, reservation.Decision.Remaining)
+
+	assert.Nil(t, reservation.Refund(ctx, 1))
+	remaining, err := limiter.GetStats(ctx, "id1", 5, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, remaining)
+
+	// only tokens not refunded yet are given back
+	assert.Nil(t, reservation.Cancel(ctx))
+	assert.Nil(t, reservation.Cancel(ctx))
+	for _, client := range []cache.CacheClient{memClient, limiter.remoteCacheClient} {
+		currentCache, err := client.GetCache(ctx, "id1")
+		assert.Nil(t, err)
+		assert.Equal(t, "5", currentCache["tokens"])
+	}
+}
+
+func TestReserveCancelExpiresFullBucket(t *testing.T) {
+	ctx := context.Background()
+	memClient := cache.NewMemCacheClient(time.Hour, time.Hour)
+	limiter := NewTokenBucketRateLimiter(memClient, newTestRedisCacheClient(t))
+
+	reservation, err := limiter.Reserve(ctx, "id1", 5, time.Minute, 2)
+	assert.Nil(t, err)
+	assert.Nil(t, reservation.Cancel(ctx))
+
+	// the bucket is full after refunding, it expires instead of staying in memcache forever
+	time.Sleep(10 * time.Millisecond)
+	currentCache, err := memClient.GetCache(ctx, "id1")
+	assert.Nil(t, err)
+	assert.Nil(t, currentCache)
+}
+
+func TestReserveRejected(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), newTestRedisCacheClient(t))
+
+	reservation, err := limiter.Reserve(ctx, "id1", 2, time.Minute, 2)
+	assert.Nil(t, err)
+	assert.True(t, reservation.Decision.Allowed)
+	rejected, err := limiter.Reserve(ctx, "id1", 2, time.Minute, 1)
+	assert.Nil(t, err)
+	assert.False(t, rejected.Decision.Allowed)
+
+	// nothing was taken by the rejected request
+	assert.Nil(t, rejected.Cancel(ctx))
+	remaining, err := limiter.GetStats(ctx, "id1", 2, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, remaining)
+}
+
+func TestReserveCancelWithSlidingWindow(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), newTestRedisCacheClient(t), WithAlgorithm(algorithm.NewSlidingWindowAlgorithm))
+
+	reservation, err := limiter.Reserve(ctx, "id1", 2, time.Hour, 2)
+	assert.Nil(t, err)
+	assert.Nil(t, reservation.Cancel(ctx))
+	decision, err := limiter.GetDecisionWithCost(ctx, "id1", 2, time.Hour, 2)
+	assert.Nil(t, err)
+	assert.True(t, decision.Allowed)
+}
+
+func TestReserveCancelRemoteCacheFailure(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), nil)
+
+	reservation, err := limiter.Reserve(ctx, "id1", 5, time.Minute, 2)
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+	assert.True(t, reservation.Decision.Allowed)
+	assert.ErrorIs(t, reservation.Cancel(ctx), ErrBackendUnavailable)
+}
@@ -3,101 +3,534 @@ package ratelimiter
 import (
 	"context"
 	"errors"
-	"strconv"
+	"fmt"
+	"math"
+	"strings"
 	"time"
 
 	"github.com/Azure/rate-limiter/pkg/algorithm"
 	"github.com/Azure/rate-limiter/pkg/cache"
+	"github.com/Azure/rate-limiter/pkg/metrics"
+	"github.com/Azure/rate-limiter/pkg/policy"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-const (
-	tokenNumberKey           = "tokens"
-	tokenLastIncreaseTimeKey = "tokenLastIncreaseTime"
-)
-
+// TokenBucketRateLimiter takes tokens from both memcache and remote cache, memcache decision is used when remote cache fails
+// it uses token bucket by default, other algorithms can be set by WithAlgorithm
 type TokenBucketRateLimiter struct {
 	memCacheClient    cache.CacheClient
 	remoteCacheClient cache.CacheClient
+	newAlgorithm      algorithm.Factory
+	overrides         *OverrideStore
+	failMode          policy.FailMode
+	metrics           *metrics.Metrics
+	// policyName is the policy label of metrics, set by PolicyRateLimiter
+	policyName string
+	localCache interface{ ItemCount() int }
+	observers  []Observer
+	shadow     bool
+}
+
+// errors returned with decisions made without the rate limiter state, match them with errors.Is
+var (
+	// ErrInvalidConfig is returned when burst size, rate or cost is wrong
+	ErrInvalidConfig = errors.New("invalid rate limiter config")
+	// ErrBackendUnavailable is returned when a cache client is missing or fails
+	ErrBackendUnavailable = errors.New("rate limiter backend unavailable")
+	// ErrCorruptState is returned when the state saved in cache can't be parsed
+	ErrCorruptState = errors.New("corrupt rate limiter state")
+)
+
+type Option func(*TokenBucketRateLimiter)
+
+// WithAlgorithm sets the algorithm built from burst size and rate of every decision
+// other algorithms than the token bucket don't have a server side script, they only get read-modify-write semantics:
+// the remote state is read with GetCache and saved with UpdateCache, which isn't atomic across replicas,
+// so replicas racing on the same key can all be allowed and exceed the limit by up to the number of replicas
+func WithAlgorithm(factory algorithm.Factory) Option {
+	return func(r *TokenBucketRateLimiter) {
+		r.newAlgorithm = factory
+	}
+}
+
+// WithOverrides consults per key overrides in store before burst size and rate passed to every decision
+func WithOverrides(store *OverrideStore) Option {
+	return func(r *TokenBucketRateLimiter) {
+		r.overrides = store
+	}
+}
+
+// WithFailMode sets the decision made when remote cache fails or config is wrong, default is policy.FailLocal
+func WithFailMode(failMode policy.FailMode) Option {
+	return func(r *TokenBucketRateLimiter) {
+		r.failMode = failMode
+	}
 }
 
-func NewTokenBucketRateLimiter(memCacheClient, remoteCacheClient cache.CacheClient) *TokenBucketRateLimiter {
-	return &TokenBucketRateLimiter{
+// WithMetrics exports metrics of decisions, and latency of cache clients which are wrapped by m
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(r *TokenBucketRateLimiter) {
+		r.metrics = m
+		if localCache, ok := r.memCacheClient.(interface{ ItemCount() int }); ok {
+			r.localCache = localCache
+		}
+		r.memCacheClient = m.InstrumentCacheClient(r.memCacheClient, string(BackendMemory))
+		r.remoteCacheClient = m.InstrumentCacheClient(r.remoteCacheClient, string(BackendRemote))
+	}
+}
+
+// WithObserver passes every decision to observer, it can be set more than once
+func WithObserver(observer Observer) Option {
+	return func(r *TokenBucketRateLimiter) {
+		r.observers = append(r.observers, observer)
+	}
+}
+
+// shadowKeyPrefix prefixes keys of shadow buckets, so shadow decisions don't take tokens of real buckets
+const shadowKeyPrefix = "shadow:"
+
+// WithShadowMode makes decisions in shadow buckets and reports them to observers and metrics, but always allows requests
+// it's used to validate a new limit against real traffic before enforcing it
+func WithShadowMode() Option {
+	return func(r *TokenBucketRateLimiter) {
+		r.shadow = true
+	}
+}
+
+func withPolicyName(name string) Option {
+	return func(r *TokenBucketRateLimiter) {
+		r.policyName = name
+	}
+}
+
+func NewTokenBucketRateLimiter(memCacheClient, remoteCacheClient cache.CacheClient, opts ...Option) *TokenBucketRateLimiter {
+	r := &TokenBucketRateLimiter{
 		memCacheClient:    memCacheClient,
 		remoteCacheClient: remoteCacheClient,
+		newAlgorithm:      algorithm.NewTokenBucketAlgorithm,
+		failMode:          policy.FailLocal,
+	}
+	for _, opt := range opts {
+		opt(r)
 	}
+	return r
 }
 
+// Backend is the cache which produced a decision
+type Backend string
+
+const (
+	BackendMemory Backend = "memory"
+	BackendRemote Backend = "remote"
+)
+
 type RateLimiterDecision struct {
 	Allowed    bool
 	RetryAfter time.Duration
+	// Limit is the configured burst size
+	Limit int
+	// Remaining is the number of tokens left after this request, nothing is taken when request is not allowed
+	Remaining int
+	// ResetAt is the time when the bucket will be full again
+	ResetAt time.Time
+	// Window is the time window Limit applies to, it's set by the algorithm, e.g. the calendar window of a fixed window quota
+	Window  time.Duration
+	Backend Backend
+	// Degraded is set when the decision isn't made by the remote cache, it's made by fail mode or memcache
+	Degraded bool
+	// Shadow is set in shadow mode, the request is allowed and ShadowRejected is set when the decision was a rejection
+	Shadow         bool
+	ShadowRejected bool
+	// Override is set when the decision is made by an allow or deny override, OverrideReason is the reason of the override
+	Override       OverrideType
+	OverrideReason string
 }
 
 // return allow decision and error
 func (r *TokenBucketRateLimiter) GetDecision(ctx context.Context, key string, burstSize int, rate time.Duration) (RateLimiterDecision, error) {
-	bucket, err := algorithm.NewBucket(rate, burstSize)
+	return r.GetDecisionWithCost(ctx, key, burstSize, rate, 1)
+}
+
+// GetDecisionWithCost takes cost tokens at once, the request is allowed only when all of them are available
+// a cost larger than burst size can never be allowed, it's rejected with algorithm.ErrCostExceedsBurstSize
+func (r *TokenBucketRateLimiter) GetDecisionWithCost(ctx context.Context, key string, burstSize int, rate time.Duration, cost int) (RateLimiterDecision, error) {
+	ctx, span := r.startSpan(ctx, "TokenBucketRateLimiter.GetDecision", cost)
+	overridden, burstSize, rate, overrideErr := applyOverride(ctx, r.overrides, key, burstSize, rate)
+	if overridden != nil {
+		r.observe(ctx, key, cost, *overridden, nil)
+		endDecisionSpan(span, *overridden, nil)
+		return r.shadowDecision(*overridden), nil
+	}
+	decision, err := r.getDecision(ctx, r.bucketKey(key), burstSize, rate, cost)
+	decision, err = withOverrideError(decision, err, overrideErr)
+	r.observe(ctx, key, cost, decision, err)
+	endDecisionSpan(span, decision, err)
+	return r.shadowDecision(decision), err
+}
+
+// bucketKey returns the cache key of the bucket of key, which is a shadow bucket in shadow mode
+func (r *TokenBucketRateLimiter) bucketKey(key string) string {
+	if r.shadow {
+		return shadowKeyPrefix + key
+	}
+	return key
+}
+
+// shadowDecision allows the request in shadow mode, the decision is returned as is otherwise
+func (r *TokenBucketRateLimiter) shadowDecision(decision RateLimiterDecision) RateLimiterDecision {
+	if !r.shadow {
+		return decision
+	}
+	decision.Shadow = true
+	decision.ShadowRejected = !decision.Allowed
+	decision.Allowed = true
+	decision.RetryAfter = 0
+	return decision
+}
+
+// observe passes a decision to observers, and exports its metrics when metrics are set
+func (r *TokenBucketRateLimiter) observe(ctx context.Context, key string, cost int, decision RateLimiterDecision, err error) {
+	if len(r.observers) > 0 {
+		event := DecisionEvent{
+			Time:     time.Now(),
+			Key:      strings.TrimPrefix(key, r.policyName+":"),
+			Policy:   r.policyName,
+			Cost:     cost,
+			Decision: decision,
+			Err:      err,
+			Shadow:   r.shadow,
+		}
+		for _, observer := range r.observers {
+			observer.ObserveDecision(ctx, event)
+		}
+	}
+	if r.metrics == nil {
+		return
+	}
+	if r.shadow {
+		r.metrics.ObserveShadowDecision(r.policyName, key, decision.Allowed)
+	} else {
+		r.metrics.ObserveDecision(r.policyName, key, decision.Allowed)
+	}
+	if reason := failReason(err); reason != "" && !errors.Is(err, ErrInvalidConfig) {
+		r.metrics.ObserveRemoteError(r.policyName, key, reason)
+	}
+	if decision.Degraded && decision.Backend == BackendMemory {
+		r.metrics.ObserveFallback(r.policyName, key)
+	}
+	if r.localCache != nil {
+		r.metrics.SetLocalCacheEntries(r.localCache.ItemCount())
+	}
+}
+
+func (r *TokenBucketRateLimiter) getDecision(ctx context.Context, key string, burstSize int, rate time.Duration, cost int) (RateLimiterDecision, error) {
+	algo, err := r.newAlgorithm(burstSize, rate)
 	if err != nil {
-		// wrong config, fail open
-		return RateLimiterDecision{Allowed: true}, err
+		return r.failDecision(rate), fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	if err = algo.ValidateCost(cost); err != nil {
+		if errors.Is(err, algorithm.ErrCostExceedsBurstSize) {
+			return RateLimiterDecision{Allowed: false}, err
+		}
+		return r.failDecision(rate), fmt.Errorf("%w: %w", ErrInvalidConfig, err)
 	}
 	// take token from both memcache and remote cache
-	allow1, err1 := takeTokenFromCache(ctx, r.remoteCacheClient, bucket, key)
+	allow1, err1 := takeTokenFromCache(ctx, r.remoteCacheClient, algo, key, cost)
+	allow1.Backend = BackendRemote
 	// memcache won't return any error
-	allow2, _ := takeTokenFromCache(ctx, r.memCacheClient, bucket, key)
-	if err1 != nil {
+	allow2, err2 := takeTokenFromCache(ctx, r.memCacheClient, algo, key, cost)
+	allow2.Backend = BackendMemory
+	if err1 == nil {
+		return allow1, nil
+	}
+	if r.failMode == policy.FailLocal && err2 == nil {
+		allow2.Degraded = true
 		return allow2, err1
 	}
-	return allow1, nil
+	return r.failDecision(rate), err1
 }
 
-// return retry after time
-func takeTokenFromCache(ctx context.Context, client cache.CacheClient, bucket *algorithm.Bucket, key string) (RateLimiterDecision, error) {
+// failDecision is the decision by fail mode when the rate limiter state isn't available
+func (r *TokenBucketRateLimiter) failDecision(rate time.Duration) RateLimiterDecision {
+	if r.failMode == policy.FailClosed {
+		return RateLimiterDecision{Allowed: false, RetryAfter: max(rate, time.Second), Degraded: true}
+	}
+	return RateLimiterDecision{Allowed: true, Degraded: true}
+}
+
+// backendError classifies an error returned by a cache client
+func backendError(err error) error {
+	if errors.Is(err, cache.ErrCorruptData) {
+		return fmt.Errorf("%w: %w", ErrCorruptState, err)
+	}
+	if errors.Is(err, cache.ErrInvalidArgument) {
+		// the cache is healthy, the limit can't be taken with these arguments
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	return fmt.Errorf("%w: %w", ErrBackendUnavailable, err)
+}
+
+// failReason returns the reason of a degraded decision from its error, it's empty for other errors
+func failReason(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidConfig):
+		return "invalid_config"
+	case errors.Is(err, ErrCorruptState):
+		return "corrupt_state"
+	case errors.Is(err, ErrBackendUnavailable):
+		return "backend_unavailable"
+	}
+	return ""
+}
+
+// newDecision converts algorithm result to decision
+func newDecision(result algorithm.Result) RateLimiterDecision {
+	decision := RateLimiterDecision{
+		Allowed:   result.Allowed,
+		Limit:     result.Limit,
+		Remaining: result.Remaining,
+		ResetAt:   result.ResetAt,
+		Window:    result.Window,
+	}
+	if !result.Allowed {
+		// too many requests, return retry after time, 429
+		decision.RetryAfter = time.Until(result.RetryAt)
+	}
+	return decision
+}
+
+// return decision with retry after time and bucket stats
+func takeTokenFromCache(ctx context.Context, client cache.CacheClient, algo algorithm.Algorithm, key string, cost int) (RateLimiterDecision, error) {
 	if client == nil {
-		return RateLimiterDecision{Allowed: true}, errors.New("cache client is nil")
+		return RateLimiterDecision{Allowed: true}, fmt.Errorf("%w: cache client is nil", ErrBackendUnavailable)
+	}
+	// prefer taking token on the server side, read and then update cache isn't atomic across replicas
+	if bucket, ok := algo.(*algorithm.Bucket); ok {
+		if tokenBucketClient, ok := client.(cache.TokenBucketCacheClient); ok {
+			return takeTokenFromTokenBucketClient(ctx, tokenBucketClient, bucket, key, cost)
+		}
 	}
 	currentCache, err := client.GetCache(ctx, key)
 	if err != nil {
-		return RateLimiterDecision{Allowed: true}, err
+		return RateLimiterDecision{Allowed: true}, backendError(err)
 	}
-	tokenNumbers, lastIncreaseTime, expireTime, err := bucket.TakeToken(currentCache)
+	result, err := algo.Take(currentCache, cost)
 	if err != nil {
 		// wrong data
-		return RateLimiterDecision{Allowed: true}, err
-	}
-	if tokenNumbers < 0 {
-		// when tokenNumber < 0 means too many requests, return retry after time, 429 and not update cache
-		retryAt := lastIncreaseTime.Add(bucket.TokenDropRate)
-		return RateLimiterDecision{
-			Allowed:    false,
-			RetryAfter: time.Until(retryAt),
-		}, nil
-	}
-	err = client.UpdateCache(ctx, key, map[string]string{
-		tokenNumberKey:           strconv.Itoa(tokenNumbers),
-		tokenLastIncreaseTimeKey: lastIncreaseTime.Format(time.RFC3339),
-	}, expireTime)
+		return RateLimiterDecision{Allowed: true}, fmt.Errorf("%w: %w", ErrCorruptState, err)
+	}
+	if !result.Allowed {
+		// not update cache
+		return newDecision(result), nil
+	}
+	err = client.UpdateCache(ctx, key, result.CacheData, result.ExpireTime)
+	if err != nil {
+		return RateLimiterDecision{Allowed: true}, backendError(err)
+	}
+	return newDecision(result), nil
+}
+
+// take token with a single call, cache is updated by the client
+func takeTokenFromTokenBucketClient(ctx context.Context, client cache.TokenBucketCacheClient, bucket *algorithm.Bucket, key string, cost int) (RateLimiterDecision, error) {
+	tokenNumbers, lastIncreaseTime, expireTime, err := client.TakeTokens(ctx, key, bucket.BurstSize, bucket.TokenDropRate, cost)
 	if err != nil {
-		return RateLimiterDecision{Allowed: true}, err
+		return RateLimiterDecision{Allowed: true}, backendError(err)
 	}
-	return RateLimiterDecision{Allowed: true}, nil
+	return newDecision(bucket.NewResult(tokenNumbers, lastIncreaseTime, expireTime, cost)), nil
+}
+
+// ErrDeniedByOverride is returned by Wait when the key is denied by an override
+var ErrDeniedByOverride = errors.New("rate limiter key is denied by override")
+
+// ErrWaitExceedsDeadline is returned by Wait when the tokens can't be available before the context deadline
+var ErrWaitExceedsDeadline = errors.New("rate limiter wait would exceed context deadline")
+
+// Wait reserves cost tokens and blocks until they are due instead of rejecting the request
+// the reservation is saved in the same bucket state, so requests waiting on other replicas queue after it
+// when the tokens can't be available before the context deadline, it returns ErrWaitExceedsDeadline at once and nothing is reserved
+// when remote cache fails, it follows the fail mode: local waits for the memcache reservation, open returns nil at once,
+// and closed returns the error, which matches ErrBackendUnavailable or ErrCorruptState
+// when ctx is done while waiting, the reserved tokens are given back and ctx.Err() is returned
+// only token bucket algorithm supports waiting
+func (r *TokenBucketRateLimiter) Wait(ctx context.Context, key string, burstSize int, rate time.Duration, cost int) (err error) {
+	if r.shadow {
+		// shadow mode never blocks, errors are reported to observers
+		_, _ = r.GetDecisionWithCost(ctx, key, burstSize, rate, cost)
+		return nil
+	}
+	ctx, span := r.startSpan(ctx, "TokenBucketRateLimiter.Wait", cost)
+	defer func() { endSpan(span, err) }()
+	reservation, readyAt, reserveErr := r.reserveWait(ctx, key, burstSize, rate, cost)
+	decision := reservation.Decision
+	r.observe(ctx, key, cost, decision, reserveErr)
+	if reason := failReason(reserveErr); reason != "" && decision.Degraded {
+		span.SetAttributes(attribute.String("ratelimiter.fallback_reason", reason))
+	}
+	if !decision.Allowed {
+		switch {
+		case decision.Override == OverrideDeny:
+			return ErrDeniedByOverride
+		case decision.Backend == "":
+			// nothing is reserved because of wrong config or fail mode
+			return reserveErr
+		default:
+			return ErrWaitExceedsDeadline
+		}
+	}
+	delay := time.Until(readyAt)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// requests reserving after it shouldn't queue behind a request which won't be sent
+		_ = reservation.Cancel(context.WithoutCancel(ctx))
+		return ctx.Err()
+	}
+}
+
+// reserveWait reserves cost tokens for Wait, it returns the reservation to give them back and the time reserved tokens are available
+func (r *TokenBucketRateLimiter) reserveWait(ctx context.Context, key string, burstSize int, rate time.Duration, cost int) (*Reservation, time.Time, error) {
+	// default limit is used when overrides can't be read
+	overridden, burstSize, rate, overrideErr := applyOverride(ctx, r.overrides, key, burstSize, rate)
+	if overridden != nil {
+		return &Reservation{Decision: *overridden}, time.Time{}, nil
+	}
+	algo, err := r.newAlgorithm(burstSize, rate)
+	if err != nil {
+		return &Reservation{}, time.Time{}, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	bucket, ok := algo.(*algorithm.Bucket)
+	if !ok {
+		return &Reservation{}, time.Time{}, fmt.Errorf("%w: wait is only supported by token bucket algorithm", ErrInvalidConfig)
+	}
+	if err = bucket.ValidateCost(cost); err != nil {
+		return &Reservation{}, time.Time{}, err
+	}
+	maxWait := time.Duration(math.MaxInt64)
+	if deadline, ok := ctx.Deadline(); ok {
+		maxWait = time.Until(deadline)
+	}
+	backend := BackendRemote
+	tokenNumbers, lastIncreaseTime, err1 := reserveTokensFromCache(ctx, r.remoteCacheClient, bucket, key, cost, maxWait)
+	if err1 != nil {
+		if r.failMode != policy.FailLocal {
+			return &Reservation{Decision: r.failDecision(rate)}, time.Time{}, err1
+		}
+		var err2 error
+		if tokenNumbers, lastIncreaseTime, err2 = reserveTokensFromCache(ctx, r.memCacheClient, bucket, key, cost, maxWait); err2 != nil {
+			return &Reservation{Decision: r.failDecision(rate)}, time.Time{}, err1
+		}
+		backend = BackendMemory
+	} else if tokenNumbers >= 0 {
+		// memcache follows remote cache, nothing is reserved when the tokens can't be available before the deadline
+		_, _, _ = reserveTokensFromCache(ctx, r.memCacheClient, bucket, key, cost, maxWait)
+	}
+	decision := newDecision(bucket.NewResult(tokenNumbers, lastIncreaseTime, 0, cost))
+	decision.Backend = backend
+	decision.Degraded = err1 != nil
+	decision, err = withOverrideError(decision, err1, overrideErr)
+	reservation := &Reservation{Decision: decision}
+	if tokenNumbers >= 0 {
+		reservation.refundable = cost
+		reservation.refund = func(ctx context.Context, cost int) error {
+			if backend == BackendMemory {
+				// remote cache failed, only memcache is reserved
+				return refundTokensToCache(ctx, r.memCacheClient, bucket, key, cost)
+			}
+			return r.refund(ctx, key, burstSize, rate, cost)
+		}
+	}
+	// last increase time is the time reserved tokens are available
+	return reservation, lastIncreaseTime, err
+}
+
+// return token number after reserving and the time reserved tokens are available
+func reserveTokensFromCache(ctx context.Context, client cache.CacheClient, bucket *algorithm.Bucket, key string, cost int, maxWait time.Duration) (int, time.Time, error) {
+	if client == nil {
+		return 0, time.Time{}, fmt.Errorf("%w: cache client is nil", ErrBackendUnavailable)
+	}
+	var tokenNumbers int
+	var lastIncreaseTime time.Time
+	var err error
+	if reserveClient, ok := client.(cache.TokenBucketReserveCacheClient); ok {
+		tokenNumbers, lastIncreaseTime, _, err = reserveClient.ReserveTokens(ctx, key, bucket.BurstSize, bucket.TokenDropRate, cost, maxWait)
+		if err != nil {
+			return 0, time.Time{}, backendError(err)
+		}
+	} else {
+		currentCache, err := client.GetCache(ctx, key)
+		if err != nil {
+			return 0, time.Time{}, backendError(err)
+		}
+		var expireTime time.Duration
+		tokenNumbers, lastIncreaseTime, expireTime, err = bucket.ReserveTokens(currentCache, cost, maxWait)
+		if err != nil {
+			return 0, time.Time{}, fmt.Errorf("%w: %w", ErrCorruptState, err)
+		}
+		if tokenNumbers >= 0 {
+			result := bucket.NewResult(tokenNumbers, lastIncreaseTime, expireTime, cost)
+			if err = client.UpdateCache(ctx, key, result.CacheData, result.ExpireTime); err != nil {
+				return 0, time.Time{}, backendError(err)
+			}
+		}
+	}
+	// last increase time is in the past when enough tokens are available
+	return tokenNumbers, lastIncreaseTime, nil
 }
 
 func (r *TokenBucketRateLimiter) GetStats(ctx context.Context, key string, burstSize int, rate time.Duration) (int, error) {
-	bucket, err := algorithm.NewBucket(rate, burstSize)
+	algo, err := r.newAlgorithm(burstSize, rate)
 	if err != nil {
 		// wrong config
-		return 0, err
+		return 0, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
 	}
+	currentCache := r.readCache(ctx, key)
+	if currentCache == nil {
+		return burstSize, nil
+	}
+	remaining, err := algo.Remaining(currentCache)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrCorruptState, err)
+	}
+	return remaining, nil
+}
+
+// Peek returns the decision GetDecisionWithCost would make now without taking any token
+func (r *TokenBucketRateLimiter) Peek(ctx context.Context, key string, burstSize int, rate time.Duration, cost int) (RateLimiterDecision, error) {
+	overridden, burstSize, rate, overrideErr := applyOverride(ctx, r.overrides, key, burstSize, rate)
+	if overridden != nil {
+		return r.shadowDecision(*overridden), nil
+	}
+	algo, err := r.newAlgorithm(burstSize, rate)
+	if err != nil {
+		return r.failDecision(rate), fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	if err = algo.ValidateCost(cost); err != nil {
+		return RateLimiterDecision{Allowed: false}, err
+	}
+	// the result isn't saved, so nothing is taken
+	result, err := algo.Take(r.readCache(ctx, r.bucketKey(key)), cost)
+	if err != nil {
+		return r.failDecision(rate), fmt.Errorf("%w: %w", ErrCorruptState, err)
+	}
+	return r.shadowDecision(newDecision(result)), overrideErr
+}
+
+// readCache reads the state of key from remote cache, or from memcache when remote cache fails
+func (r *TokenBucketRateLimiter) readCache(ctx context.Context, key string) map[string]string {
 	var currentCache map[string]string
-	var err2 error
+	var err error
 	if r.remoteCacheClient != nil {
-		currentCache, err2 = r.remoteCacheClient.GetCache(ctx, key)
+		currentCache, err = r.remoteCacheClient.GetCache(ctx, key)
 	}
-	if r.remoteCacheClient == nil || err2 != nil {
+	if r.remoteCacheClient == nil || err != nil {
 		// use memcache
 		currentCache, _ = r.memCacheClient.GetCache(ctx, key)
 	}
-	if currentCache == nil {
-		return burstSize, nil
-	}
-	return bucket.GetTokenNumber(currentCache)
+	return currentCache
 }
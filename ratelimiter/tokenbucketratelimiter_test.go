@@ -1,7 +1,388 @@
 package ratelimiter
 
-import "testing"
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/rate-limiter/pkg/algorithm"
+	"github.com/Azure/rate-limiter/pkg/cache"
+	"github.com/Azure/rate-limiter/pkg/metrics"
+	"github.com/Azure/rate-limiter/pkg/policy"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRedisCacheClient(t *testing.T) *cache.RedisClient {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return cache.NewRedisClient(context.Background(), client)
+}
 
 func TestTakeTokenFromCache(t *testing.T) {
-	
+	ctx := context.Background()
+	bucket, err := algorithm.NewBucket(time.Minute, 2)
+	assert.Nil(t, err)
+	memClient := cache.NewMemCacheClient(time.Minute, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		decision, err := takeTokenFromCache(ctx, memClient, bucket, "id1", 1)
+		assert.Nil(t, err)
+		assert.True(t, decision.Allowed)
+	}
+	decision, err := takeTokenFromCache(ctx, memClient, bucket, "id1", 1)
+	assert.Nil(t, err)
+	assert.False(t, decision.Allowed)
+	assert.True(t, decision.RetryAfter > 0 && decision.RetryAfter <= time.Minute)
+
+	_, err = takeTokenFromCache(ctx, nil, bucket, "id1", 1)
+	assert.NotNil(t, err)
+}
+
+func TestTakeTokenFromTokenBucketCacheClient(t *testing.T) {
+	ctx := context.Background()
+	bucket, err := algorithm.NewBucket(time.Minute, 2)
+	assert.Nil(t, err)
+	redisClient := newTestRedisCacheClient(t)
+
+	for i := 0; i < 2; i++ {
+		decision, err := takeTokenFromCache(ctx, redisClient, bucket, "id1", 1)
+		assert.Nil(t, err)
+		assert.True(t, decision.Allowed)
+	}
+	decision, err := takeTokenFromCache(ctx, redisClient, bucket, "id1", 1)
+	assert.Nil(t, err)
+	assert.False(t, decision.Allowed)
+	assert.True(t, decision.RetryAfter > 0 && decision.RetryAfter <= time.Minute)
+}
+
+func TestGetDecision(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), newTestRedisCacheClient(t))
+
+	for i := 0; i < 3; i++ {
+		decision, err := rateLimiter.GetDecision(ctx, "id1", 3, time.Minute)
+		assert.Nil(t, err)
+		assert.True(t, decision.Allowed)
+	}
+	decision, err := rateLimiter.GetDecision(ctx, "id1", 3, time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, decision.Allowed)
+
+	tokenNumber, err := rateLimiter.GetStats(ctx, "id1", 3, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, tokenNumber)
+
+	_, err = rateLimiter.GetStats(ctx, "id1", 0, time.Minute)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestGetDecisionFailMode(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	remoteCacheClient := cache.NewRedisClient(ctx, client)
+
+	// corrupt state in remote cache
+	server.HSet("id1", "tokens", "wrong")
+	rateLimiter := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), remoteCacheClient)
+	decision, err := rateLimiter.GetDecision(ctx, "id1", 1, time.Minute)
+	assert.ErrorIs(t, err, ErrCorruptState)
+	assert.True(t, decision.Allowed)
+	assert.True(t, decision.Degraded)
+	assert.Equal(t, BackendMemory, decision.Backend)
+
+	// remote cache is down, local decision is made by memcache
+	server.Close()
+	decision, err = rateLimiter.GetDecision(ctx, "id1", 1, time.Minute)
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+	assert.False(t, decision.Allowed)
+	assert.True(t, decision.Degraded)
+
+	rateLimiter = NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), remoteCacheClient, WithFailMode(policy.FailOpen))
+	decision, err = rateLimiter.GetDecision(ctx, "id1", 1, time.Minute)
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+	assert.True(t, decision.Allowed)
+	assert.True(t, decision.Degraded)
+
+	rateLimiter = NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), remoteCacheClient, WithFailMode(policy.FailClosed))
+	decision, err = rateLimiter.GetDecision(ctx, "id1", 1, time.Minute)
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, time.Minute, decision.RetryAfter)
+	assert.True(t, decision.Degraded)
+
+	// wrong config
+	decision, err = rateLimiter.GetDecision(ctx, "id1", 0, time.Minute)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.False(t, decision.Allowed)
+
+	// zero rate is rejected before any bucket is saved, the second decision reads the cache
+	rateLimiter = NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), cache.NewMemCacheClient(time.Minute, time.Minute))
+	for i := 0; i < 2; i++ {
+		_, err = rateLimiter.GetDecision(ctx, "id2", 5, 0)
+		assert.ErrorIs(t, err, ErrInvalidConfig)
+	}
+}
+
+func TestGetDecisionWithCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	breaker := cache.NewCircuitBreaker(cache.WithFailureThreshold(1))
+	remoteCacheClient := cache.NewCircuitBreakerClient(cache.NewRedisClient(ctx, client), breaker)
+	rateLimiter := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), remoteCacheClient)
+
+	server.Close()
+	_, err := rateLimiter.GetDecision(ctx, "id1", 2, time.Minute)
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+	assert.Equal(t, cache.CircuitOpen, breaker.State())
+
+	// memcache decides without calling remote cache
+	decision, err := rateLimiter.GetDecision(ctx, "id1", 2, time.Minute)
+	assert.ErrorIs(t, err, cache.ErrCircuitOpen)
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+	assert.True(t, decision.Allowed)
+	assert.True(t, decision.Degraded)
+	assert.Equal(t, BackendMemory, decision.Backend)
+}
+
+func TestGetDecisionWithMetrics(t *testing.T) {
+	ctx := context.Background()
+	registry := prometheus.NewRegistry()
+	m, err := metrics.NewMetrics(registry)
+	assert.Nil(t, err)
+	rateLimiter := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), nil, WithMetrics(m))
+
+	for i := 0; i < 2; i++ {
+		_, err := rateLimiter.GetDecision(ctx, "id1", 1, time.Minute)
+		assert.ErrorIs(t, err, ErrBackendUnavailable)
+	}
+	expected := `
+# HELP rate_limiter_decisions_total Number of rate limiter decisions by policy and result, allowed or denied.
+# TYPE rate_limiter_decisions_total counter
+rate_limiter_decisions_total{policy="",result="allowed"} 1
+rate_limiter_decisions_total{policy="",result="denied"} 1
+# HELP rate_limiter_memory_fallbacks_total Number of decisions made by memcache because remote cache failed, by policy.
+# TYPE rate_limiter_memory_fallbacks_total counter
+rate_limiter_memory_fallbacks_total{policy=""} 2
+# HELP rate_limiter_local_cache_entries Number of entries in memcache, updated after every decision.
+# TYPE rate_limiter_local_cache_entries gauge
+rate_limiter_local_cache_entries 1
+`
+	assert.Nil(t, testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"rate_limiter_decisions_total", "rate_limiter_memory_fallbacks_total", "rate_limiter_local_cache_entries"))
+	count, err := testutil.GatherAndCount(registry, "rate_limiter_cache_operation_duration_seconds")
+	assert.Nil(t, err)
+	// get and update of the first decision, get of the second one
+	assert.Equal(t, 2, count)
+}
+
+func TestGetDecisionWithCost(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), newTestRedisCacheClient(t))
+
+	decision, err := rateLimiter.GetDecisionWithCost(ctx, "id1", 10, time.Minute, 8)
+	assert.Nil(t, err)
+	assert.True(t, decision.Allowed)
+
+	// 2 tokens left, the 3rd one will be added in a minute
+	decision, err = rateLimiter.GetDecisionWithCost(ctx, "id1", 10, time.Minute, 3)
+	assert.Nil(t, err)
+	assert.False(t, decision.Allowed)
+	assert.True(t, decision.RetryAfter > 0 && decision.RetryAfter <= time.Minute)
+
+	decision, err = rateLimiter.GetDecisionWithCost(ctx, "id1", 10, time.Minute, 11)
+	assert.ErrorIs(t, err, algorithm.ErrCostExceedsBurstSize)
+	assert.False(t, decision.Allowed)
+}
+
+func TestGetDecisionMetadata(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), newTestRedisCacheClient(t))
+
+	before := time.Now().Truncate(time.Microsecond)
+	decision, err := rateLimiter.GetDecisionWithCost(ctx, "id1", 5, time.Minute, 2)
+	after := time.Now()
+	assert.Nil(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, 5, decision.Limit)
+	assert.Equal(t, 3, decision.Remaining)
+	assert.Equal(t, BackendRemote, decision.Backend)
+	// 2 tokens to be full
+	assert.False(t, decision.ResetAt.Before(before.Add(2*time.Minute)))
+	assert.False(t, decision.ResetAt.After(after.Add(2*time.Minute+time.Microsecond)))
+	resetAt := decision.ResetAt
+
+	// rejected request doesn't take any token, reset time is read from the saved bucket
+	decision, err = rateLimiter.GetDecisionWithCost(ctx, "id1", 5, time.Minute, 4)
+	assert.Nil(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, 3, decision.Remaining)
+	assert.Equal(t, resetAt.UnixMicro(), decision.ResetAt.UnixMicro())
+
+	// memcache is used without remote cache
+	rateLimiter = NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), nil)
+	decision, err = rateLimiter.GetDecision(ctx, "id1", 5, time.Minute)
+	assert.NotNil(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, 4, decision.Remaining)
+	assert.Equal(t, BackendMemory, decision.Backend)
+}
+
+func TestGetDecisionWithSlidingWindow(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), newTestRedisCacheClient(t), WithAlgorithm(algorithm.NewSlidingWindowAlgorithm))
+
+	// 3 requests per rolling hour
+	for i := 0; i < 3; i++ {
+		decision, err := rateLimiter.GetDecision(ctx, "id1", 3, 20*time.Minute)
+		assert.Nil(t, err)
+		assert.True(t, decision.Allowed)
+		assert.Equal(t, BackendRemote, decision.Backend)
+	}
+	decision, err := rateLimiter.GetDecision(ctx, "id1", 3, 20*time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, decision.Allowed)
+	assert.True(t, decision.RetryAfter > 0)
+
+	remaining, err := rateLimiter.GetStats(ctx, "id1", 3, 20*time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, remaining)
+}
+
+func TestGetDecisionWithGCRA(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), newTestRedisCacheClient(t), WithAlgorithm(algorithm.NewGCRAAlgorithm))
+
+	for i := 0; i < 2; i++ {
+		decision, err := rateLimiter.GetDecision(ctx, "id1", 2, time.Minute)
+		assert.Nil(t, err)
+		assert.True(t, decision.Allowed)
+	}
+	decision, err := rateLimiter.GetDecision(ctx, "id1", 2, time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, decision.Allowed)
+	assert.InDelta(t, float64(time.Minute), float64(decision.RetryAfter), float64(time.Second))
+}
+
+func TestWait(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), newTestRedisCacheClient(t))
+
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, limiter.Wait(ctx, "id1", 2, time.Second, 1))
+	}
+
+	// the next token is added within 1s
+	start := time.Now()
+	waitCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	assert.Nil(t, limiter.Wait(waitCtx, "id1", 2, time.Second, 1))
+	assert.True(t, time.Since(start) <= 2*time.Second)
+
+	// fail fast without reserving when the token can't be available before deadline
+	start = time.Now()
+	shortCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	assert.Nil(t, limiter.Wait(shortCtx, "id2", 1, time.Minute, 1))
+	assert.ErrorIs(t, limiter.Wait(shortCtx, "id2", 1, time.Minute, 1), ErrWaitExceedsDeadline)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+
+	limiter = NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), nil, WithAlgorithm(algorithm.NewGCRAAlgorithm))
+	assert.NotNil(t, limiter.Wait(ctx, "id1", 2, time.Second, 1))
+}
+
+func TestWaitFailMode(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	remoteCacheClient := cache.NewRedisClient(ctx, client)
+	server.Close()
+	shortCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	// memcache reservation is used by default
+	rateLimiter := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), remoteCacheClient)
+	assert.Nil(t, rateLimiter.Wait(shortCtx, "id1", 1, time.Minute, 1))
+	assert.ErrorIs(t, rateLimiter.Wait(shortCtx, "id1", 1, time.Minute, 1), ErrWaitExceedsDeadline)
+
+	rateLimiter = NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), remoteCacheClient, WithFailMode(policy.FailOpen))
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, rateLimiter.Wait(shortCtx, "id1", 1, time.Minute, 1))
+	}
+
+	rateLimiter = NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), remoteCacheClient, WithFailMode(policy.FailClosed))
+	assert.ErrorIs(t, rateLimiter.Wait(shortCtx, "id1", 1, time.Minute, 1), ErrBackendUnavailable)
+	rateLimiter = NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), nil, WithFailMode(policy.FailClosed))
+	assert.ErrorIs(t, rateLimiter.Wait(shortCtx, "id1", 1, time.Minute, 1), ErrBackendUnavailable)
+}
+
+func TestWaitExceedsDeadlineKeepsMemCache(t *testing.T) {
+	ctx := context.Background()
+	remoteClient := newTestRedisCacheClient(t)
+	memClient := cache.NewMemCacheClient(time.Minute, time.Minute)
+	limiter := NewTokenBucketRateLimiter(memClient, remoteClient)
+	// the remote bucket is drained by another replica
+	other := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), remoteClient)
+	assert.Nil(t, other.Wait(ctx, "id1", 1, time.Minute, 1))
+
+	shortCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	assert.ErrorIs(t, limiter.Wait(shortCtx, "id1", 1, time.Minute, 1), ErrWaitExceedsDeadline)
+	currentCache, err := memClient.GetCache(ctx, "id1")
+	assert.Nil(t, err)
+	assert.Nil(t, currentCache)
+}
+
+func TestWaitCanceledRefunds(t *testing.T) {
+	ctx := context.Background()
+	remoteClient := newTestRedisCacheClient(t)
+	memClient := cache.NewMemCacheClient(time.Minute, time.Minute)
+	limiter := NewTokenBucketRateLimiter(memClient, remoteClient)
+	assert.Nil(t, limiter.Wait(ctx, "id1", 1, time.Minute, 1))
+
+	// the next token is reserved, then the caller gives up
+	cancelCtx, cancel := context.WithCancel(ctx)
+	time.AfterFunc(50*time.Millisecond, cancel)
+	assert.ErrorIs(t, limiter.Wait(cancelCtx, "id1", 1, time.Minute, 1), context.Canceled)
+
+	// the reserved token is given back in both caches, the next waiter doesn't queue behind it
+	bucket, err := algorithm.NewBucket(time.Minute, 1)
+	assert.Nil(t, err)
+	for _, client := range []cache.CacheClient{remoteClient, memClient} {
+		currentCache, err := client.GetCache(ctx, "id1")
+		assert.Nil(t, err)
+		_, lastIncreaseTime, _, err := bucket.ReserveTokens(currentCache, 1, 2*time.Minute)
+		assert.Nil(t, err)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), lastIncreaseTime, time.Second)
+	}
+}
+
+func TestShadowMode(t *testing.T) {
+	ctx := context.Background()
+	rateLimiter := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), newTestRedisCacheClient(t), WithShadowMode())
+
+	reservation, err := rateLimiter.Reserve(ctx, "id1", 1, time.Minute, 1)
+	assert.Nil(t, err)
+	assert.True(t, reservation.Decision.Allowed)
+	assert.False(t, reservation.Decision.ShadowRejected)
+	// wait never blocks in shadow mode
+	start := time.Now()
+	assert.Nil(t, rateLimiter.Wait(ctx, "id1", 1, time.Minute, 1))
+	assert.True(t, time.Since(start) < time.Second)
+
+	// refund goes to the shadow bucket
+	assert.Nil(t, reservation.Cancel(ctx))
+	decision, err := rateLimiter.GetDecision(ctx, "id1", 1, time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, decision.ShadowRejected)
 }
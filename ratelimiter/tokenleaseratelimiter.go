@@ -0,0 +1,341 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Azure/rate-limiter/pkg/algorithm"
+	"github.com/Azure/rate-limiter/pkg/cache"
+)
+
+const (
+	DefaultTokenLeaseSize = 10
+	DefaultTokenLeaseTTL  = time.Second
+)
+
+// BackendLease is the backend of decisions served from tokens leased by TokenLeaseRateLimiter
+const BackendLease Backend = "lease"
+
+// TokenLeaseRateLimiter is the aggregated counting + local counting design, every replica leases a batch of tokens
+// from the remote bucket with a single call, and serves decisions from the lease without calling remote cache
+// a lease is renewed in the background when it runs low, and unused tokens are refunded when it expires or on Close
+// larger leases cut more remote calls, but up to lease size tokens per replica can be held while other replicas are rejected
+// it wraps a TokenBucketRateLimiter, whose remote cache client must implement cache.TokenBucketCacheClient and
+// cache.TokenBucketRefundCacheClient, decisions are passed to it when tokens can't be leased, e.g. remote cache fails,
+// other algorithms or shadow mode are used, or cost is larger than lease size
+type TokenLeaseRateLimiter struct {
+	rateLimiter *TokenBucketRateLimiter
+	leaseSize   int
+	leaseTTL    time.Duration
+
+	mu     sync.Mutex
+	leases map[string]*tokenLease
+	closed bool
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// tokenLease is the tokens leased for a key, they are already taken from the remote bucket
+type tokenLease struct {
+	bucket *algorithm.Bucket
+	tokens int
+	// remoteRemaining is the number of tokens left in the remote bucket after the last lease
+	remoteRemaining int
+	expiresAt       time.Time
+	renewing        bool
+}
+
+type TokenLeaseOption func(*TokenLeaseRateLimiter)
+
+// WithTokenLeaseSize sets the number of tokens leased at once, it's capped at burst size, default is 10, also used when size isn't greater than 0
+func WithTokenLeaseSize(size int) TokenLeaseOption {
+	return func(r *TokenLeaseRateLimiter) {
+		r.leaseSize = size
+	}
+}
+
+// WithTokenLeaseTTL sets how long leased tokens are held before unused ones are refunded, default is 1s, also used when ttl isn't greater than 0
+func WithTokenLeaseTTL(ttl time.Duration) TokenLeaseOption {
+	return func(r *TokenLeaseRateLimiter) {
+		r.leaseTTL = ttl
+	}
+}
+
+// NewTokenLeaseRateLimiter starts a goroutine refunding expired leases, Close must be called on shutdown
+func NewTokenLeaseRateLimiter(rateLimiter *TokenBucketRateLimiter, opts ...TokenLeaseOption) *TokenLeaseRateLimiter {
+	r := &TokenLeaseRateLimiter{
+		rateLimiter: rateLimiter,
+		leaseSize:   DefaultTokenLeaseSize,
+		leaseTTL:    DefaultTokenLeaseTTL,
+		leases:      map[string]*tokenLease{},
+		stop:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.leaseSize <= 0 {
+		r.leaseSize = DefaultTokenLeaseSize
+	}
+	if r.leaseTTL <= 0 {
+		// the ticker refunding expired leases panics on a non-positive interval
+		r.leaseTTL = DefaultTokenLeaseTTL
+	}
+	r.wg.Add(1)
+	go r.refundExpiredLeases()
+	return r
+}
+
+func (r *TokenLeaseRateLimiter) GetDecision(ctx context.Context, key string, burstSize int, rate time.Duration) (RateLimiterDecision, error) {
+	return r.GetDecisionWithCost(ctx, key, burstSize, rate, 1)
+}
+
+// GetDecisionWithCost takes cost tokens from the lease of key, a new lease is taken when it's missing, expired or empty
+func (r *TokenLeaseRateLimiter) GetDecisionWithCost(ctx context.Context, key string, burstSize int, rate time.Duration, cost int) (RateLimiterDecision, error) {
+	overridden, burstSize, rate, overrideErr := applyOverride(ctx, r.rateLimiter.overrides, key, burstSize, rate)
+	if overridden != nil {
+		return r.rateLimiter.GetDecisionWithCost(ctx, key, burstSize, rate, cost)
+	}
+	if overrideErr != nil {
+		// the failed read is cached, the wrapped rate limiter uses the default limit without reading overrides again
+		decision, err := r.rateLimiter.GetDecisionWithCost(ctx, key, burstSize, rate, cost)
+		return withOverrideError(decision, err, overrideErr)
+	}
+	client, bucket, ok := r.leaseClient(burstSize, rate, cost)
+	if !ok {
+		return r.rateLimiter.GetDecisionWithCost(ctx, key, burstSize, rate, cost)
+	}
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return r.rateLimiter.GetDecisionWithCost(ctx, key, burstSize, rate, cost)
+	}
+	lease := r.leases[key]
+	if lease != nil && lease.valid(bucket) && lease.tokens >= cost {
+		lease.tokens -= cost
+		decision := lease.decision()
+		// renew before the lease is empty, so requests don't wait for remote cache
+		if lease.tokens*4 <= r.size(burstSize) && !lease.renewing {
+			lease.renewing = true
+			r.wg.Add(1)
+			go r.renew(context.WithoutCancel(ctx), client, key, lease)
+		}
+		r.mu.Unlock()
+		r.rateLimiter.observe(ctx, key, cost, decision, nil)
+		return decision, nil
+	}
+	if lease != nil && lease.renewing {
+		// only one lease call per key is in flight, so a burst of requests doesn't lease a batch each
+		r.mu.Unlock()
+		return r.rateLimiter.GetDecisionWithCost(ctx, key, burstSize, rate, cost)
+	}
+	var expired *tokenLease
+	if lease == nil || !lease.valid(bucket) {
+		// tokens of an expired lease are refunded
+		expired = lease
+		lease = &tokenLease{bucket: bucket}
+		r.leases[key] = lease
+	}
+	lease.renewing = true
+	r.mu.Unlock()
+	if expired != nil {
+		_ = r.refundLeases(ctx, map[string]*tokenLease{key: expired})
+	}
+
+	decision, err := r.lease(ctx, client, key, lease, cost)
+	if err != nil {
+		return r.rateLimiter.GetDecisionWithCost(ctx, key, burstSize, rate, cost)
+	}
+	r.rateLimiter.observe(ctx, key, cost, decision, nil)
+	return decision, nil
+}
+
+// Reserve isn't served from leases, it's passed to the wrapped rate limiter
+func (r *TokenLeaseRateLimiter) Reserve(ctx context.Context, key string, burstSize int, rate time.Duration, cost int) (*Reservation, error) {
+	return r.rateLimiter.Reserve(ctx, key, burstSize, rate, cost)
+}
+
+// Wait isn't served from leases, it's passed to the wrapped rate limiter
+func (r *TokenLeaseRateLimiter) Wait(ctx context.Context, key string, burstSize int, rate time.Duration, cost int) error {
+	return r.rateLimiter.Wait(ctx, key, burstSize, rate, cost)
+}
+
+// Close refunds unused tokens of all leases, later decisions are passed to the wrapped rate limiter
+func (r *TokenLeaseRateLimiter) Close(ctx context.Context) error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	leases := map[string]*tokenLease{}
+	for key, lease := range r.leases {
+		// renewing leases are refunded by renew
+		if !lease.renewing {
+			leases[key] = lease
+		}
+	}
+	r.leases = map[string]*tokenLease{}
+	r.mu.Unlock()
+	close(r.stop)
+	r.wg.Wait()
+	return r.refundLeases(ctx, leases)
+}
+
+// leaseClient returns the remote cache client and bucket when tokens can be leased for the decision
+func (r *TokenLeaseRateLimiter) leaseClient(burstSize int, rate time.Duration, cost int) (cache.ScriptCacheClient, *algorithm.Bucket, bool) {
+	client, ok := r.rateLimiter.remoteCacheClient.(cache.ScriptCacheClient)
+	if !ok || r.rateLimiter.shadow || cost > r.size(burstSize) {
+		return nil, nil, false
+	}
+	algo, err := r.rateLimiter.newAlgorithm(burstSize, rate)
+	if err != nil {
+		return nil, nil, false
+	}
+	bucket, ok := algo.(*algorithm.Bucket)
+	if !ok || bucket.ValidateCost(cost) != nil {
+		return nil, nil, false
+	}
+	return client, bucket, true
+}
+
+// size is the number of tokens leased at once for burst size
+func (r *TokenLeaseRateLimiter) size(burstSize int) int {
+	return max(min(r.leaseSize, burstSize), 1)
+}
+
+// lease takes a batch of tokens for key to lease, and cost of this request from it,
+// when the batch isn't available only cost is taken, so the decision is the same as without leasing
+// lease must be marked renewing by the caller, so no other lease call of key is in flight
+func (r *TokenLeaseRateLimiter) lease(ctx context.Context, client cache.TokenBucketCacheClient, key string, lease *tokenLease, cost int) (RateLimiterDecision, error) {
+	bucket := lease.bucket
+	size := r.size(bucket.BurstSize)
+	tokenNumbers, lastIncreaseTime, expireTime, err := client.TakeTokens(ctx, key, bucket.BurstSize, bucket.TokenDropRate, size)
+	if err != nil || tokenNumbers < 0 {
+		r.mu.Lock()
+		lease.renewing = false
+		r.mu.Unlock()
+		if err != nil {
+			return RateLimiterDecision{}, err
+		}
+		if size == cost {
+			return r.remoteDecision(bucket, tokenNumbers, lastIncreaseTime, expireTime, cost), nil
+		}
+		tokenNumbers, lastIncreaseTime, expireTime, err = client.TakeTokens(ctx, key, bucket.BurstSize, bucket.TokenDropRate, cost)
+		if err != nil {
+			return RateLimiterDecision{}, err
+		}
+		return r.remoteDecision(bucket, tokenNumbers, lastIncreaseTime, expireTime, cost), nil
+	}
+
+	r.mu.Lock()
+	lease.renewing = false
+	if r.closed || r.leases[key] != lease {
+		r.mu.Unlock()
+		// closed while leasing, the decision is made, refund errors are left to the bucket refill
+		leased := &tokenLease{bucket: bucket, tokens: size - cost, remoteRemaining: tokenNumbers}
+		_ = r.refundLeases(ctx, map[string]*tokenLease{key: leased})
+		return leased.decision(), nil
+	}
+	lease.tokens += size - cost
+	lease.remoteRemaining = tokenNumbers
+	lease.expiresAt = time.Now().Add(r.leaseTTL)
+	decision := lease.decision()
+	r.mu.Unlock()
+	return decision, nil
+}
+
+func (r *TokenLeaseRateLimiter) remoteDecision(bucket *algorithm.Bucket, tokenNumbers int, lastIncreaseTime time.Time, expireTime time.Duration, cost int) RateLimiterDecision {
+	decision := newDecision(bucket.NewResult(tokenNumbers, lastIncreaseTime, expireTime, cost))
+	decision.Backend = BackendRemote
+	return decision
+}
+
+// renew adds a batch of tokens to lease in the background
+func (r *TokenLeaseRateLimiter) renew(ctx context.Context, client cache.TokenBucketCacheClient, key string, lease *tokenLease) {
+	defer r.wg.Done()
+	ctx, cancel := context.WithTimeout(ctx, r.leaseTTL)
+	defer cancel()
+	size := r.size(lease.bucket.BurstSize)
+	tokenNumbers, _, _, err := client.TakeTokens(ctx, key, lease.bucket.BurstSize, lease.bucket.TokenDropRate, size)
+	r.mu.Lock()
+	lease.renewing = false
+	if err != nil || tokenNumbers < 0 {
+		size = 0
+	}
+	if !r.closed && r.leases[key] == lease {
+		// the lease is used until it's empty when renewal fails, then a new lease is taken by the request
+		lease.tokens += size
+		if size > 0 {
+			lease.remoteRemaining = tokenNumbers
+			lease.expiresAt = time.Now().Add(r.leaseTTL)
+		}
+		r.mu.Unlock()
+		return
+	}
+	// the lease is replaced or closed, nobody takes tokens from it any more
+	refund := &tokenLease{bucket: lease.bucket, tokens: lease.tokens + size}
+	r.mu.Unlock()
+	_ = r.refundLeases(ctx, map[string]*tokenLease{key: refund})
+}
+
+// refundExpiredLeases refunds unused tokens of expired leases until Close
+func (r *TokenLeaseRateLimiter) refundExpiredLeases() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.leaseTTL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		expired := map[string]*tokenLease{}
+		r.mu.Lock()
+		for key, lease := range r.leases {
+			if !lease.renewing && now.After(lease.expiresAt) {
+				expired[key] = lease
+				delete(r.leases, key)
+			}
+		}
+		r.mu.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), r.leaseTTL)
+		_ = r.refundLeases(ctx, expired)
+		cancel()
+	}
+}
+
+// refundLeases gives unused tokens of leases back to the remote buckets
+func (r *TokenLeaseRateLimiter) refundLeases(ctx context.Context, leases map[string]*tokenLease) error {
+	client, ok := r.rateLimiter.remoteCacheClient.(cache.TokenBucketRefundCacheClient)
+	if !ok {
+		return nil
+	}
+	var errs error
+	for key, lease := range leases {
+		if lease.tokens <= 0 {
+			continue
+		}
+		_, _, _, err := client.RefundTokens(ctx, key, lease.bucket.BurstSize, lease.bucket.TokenDropRate, lease.tokens)
+		errs = errors.Join(errs, err)
+	}
+	return errs
+}
+
+// valid returns false when the lease is expired, or it's taken with another burst size or rate
+func (l *tokenLease) valid(bucket *algorithm.Bucket) bool {
+	return time.Now().Before(l.expiresAt) && l.bucket.BurstSize == bucket.BurstSize && l.bucket.TokenDropRate == bucket.TokenDropRate
+}
+
+// decision is an allowed decision, remaining counts tokens of the lease and of the remote bucket at the last lease
+func (l *tokenLease) decision() RateLimiterDecision {
+	return RateLimiterDecision{
+		Allowed:   true,
+		Limit:     l.bucket.BurstSize,
+		Window:    time.Duration(l.bucket.BurstSize) * l.bucket.TokenDropRate,
+		Remaining: l.tokens + l.remoteRemaining,
+		Backend:   BackendLease,
+	}
+}
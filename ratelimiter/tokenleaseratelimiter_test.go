@@ -0,0 +1,135 @@
+package ratelimiter
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Azure/rate-limiter/pkg/cache"
+)
+
+func newTestTokenLeaseRateLimiter(t *testing.T, remoteCacheClient cache.CacheClient, opts ...TokenLeaseOption) (*TokenLeaseRateLimiter, *TokenBucketRateLimiter) {
+	rateLimiter := NewTokenBucketRateLimiter(cache.NewMemCacheClient(time.Minute, time.Minute), remoteCacheClient)
+	leaseRateLimiter := NewTokenLeaseRateLimiter(rateLimiter, opts...)
+	t.Cleanup(func() { leaseRateLimiter.Close(context.Background()) })
+	return leaseRateLimiter, rateLimiter
+}
+
+func TestTokenLeaseGetDecision(t *testing.T) {
+	ctx := context.Background()
+	limiter, rateLimiter := newTestTokenLeaseRateLimiter(t, newTestRedisCacheClient(t), WithTokenLeaseSize(4))
+
+	decision, err := limiter.GetDecision(ctx, "id1", 10, time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, 9, decision.Remaining)
+	tokens, err := rateLimiter.GetStats(ctx, "id1", 10, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, tokens)
+
+	// served from the lease without taking remote tokens
+	decision, err = limiter.GetDecision(ctx, "id1", 10, time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, BackendLease, decision.Backend)
+	tokens, err = rateLimiter.GetStats(ctx, "id1", 10, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, tokens)
+
+	for i := 0; i < 3; i++ {
+		decision, err = limiter.GetDecision(ctx, "id1", 10, time.Minute)
+		assert.Nil(t, err)
+		assert.True(t, decision.Allowed)
+	}
+	// unused tokens of the lease and its renewals are refunded on close
+	assert.Nil(t, limiter.Close(ctx))
+	tokens, err = rateLimiter.GetStats(ctx, "id1", 10, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, tokens)
+
+	// decisions are made by the wrapped rate limiter after close
+	decision, err = limiter.GetDecision(ctx, "id1", 10, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, BackendRemote, decision.Backend)
+}
+
+func TestTokenLeaseWrongOptions(t *testing.T) {
+	ctx := context.Background()
+	limiter, rateLimiter := newTestTokenLeaseRateLimiter(t, newTestRedisCacheClient(t), WithTokenLeaseSize(0), WithTokenLeaseTTL(-time.Second))
+
+	// defaults are used instead
+	decision, err := limiter.GetDecision(ctx, "id1", 20, time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, decision.Allowed)
+	tokens, err := rateLimiter.GetStats(ctx, "id1", 20, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, 20-DefaultTokenLeaseSize, tokens)
+}
+
+func TestTokenLeaseConcurrent(t *testing.T) {
+	ctx := context.Background()
+	limiter, rateLimiter := newTestTokenLeaseRateLimiter(t, newTestRedisCacheClient(t), WithTokenLeaseSize(10), WithTokenLeaseTTL(time.Minute))
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			decision, err := limiter.GetDecision(ctx, "id1", 100, time.Minute)
+			assert.Nil(t, err)
+			assert.True(t, decision.Allowed)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	// one lease and one renewal at most, requests waiting for a lease take only their cost
+	tokens, err := rateLimiter.GetStats(ctx, "id1", 100, time.Minute)
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, tokens, 100-2*10-20)
+}
+
+func TestTokenLeaseRejected(t *testing.T) {
+	ctx := context.Background()
+	limiter, _ := newTestTokenLeaseRateLimiter(t, newTestRedisCacheClient(t), WithTokenLeaseSize(2))
+
+	for i := 0; i < 2; i++ {
+		decision, err := limiter.GetDecision(ctx, "id1", 2, time.Minute)
+		assert.Nil(t, err)
+		assert.True(t, decision.Allowed)
+	}
+	decision, err := limiter.GetDecision(ctx, "id1", 2, time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, BackendRemote, decision.Backend)
+	assert.True(t, decision.RetryAfter > 0 && decision.RetryAfter <= time.Minute)
+}
+
+func TestTokenLeaseExpired(t *testing.T) {
+	ctx := context.Background()
+	limiter, rateLimiter := newTestTokenLeaseRateLimiter(t, newTestRedisCacheClient(t), WithTokenLeaseSize(5), WithTokenLeaseTTL(50*time.Millisecond))
+
+	_, err := limiter.GetDecision(ctx, "id1", 10, time.Minute)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		tokens, err := rateLimiter.GetStats(ctx, "id1", 10, time.Minute)
+		return err == nil && tokens == 9
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestTokenLeaseFallback(t *testing.T) {
+	ctx := context.Background()
+	// remote cache is missing
+	limiter, _ := newTestTokenLeaseRateLimiter(t, nil)
+
+	decision, err := limiter.GetDecision(ctx, "id1", 10, time.Minute)
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, BackendMemory, decision.Backend)
+	assert.True(t, decision.Degraded)
+}
@@ -0,0 +1,55 @@
+package ratelimiter
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer is looked up on every call like the tracer of cache clients
+func tracer() trace.Tracer {
+	return otel.Tracer("github.com/Azure/rate-limiter/ratelimiter")
+}
+
+// startSpan starts the span of a rate limiter call, its parent is the span of the incoming request in ctx
+func (r *TokenBucketRateLimiter) startSpan(ctx context.Context, name string, cost int) (context.Context, trace.Span) {
+	attributes := []attribute.KeyValue{attribute.Int("ratelimiter.cost", cost)}
+	if r.policyName != "" {
+		attributes = append(attributes, attribute.String("ratelimiter.policy", r.policyName))
+	}
+	if r.shadow {
+		attributes = append(attributes, attribute.Bool("ratelimiter.shadow", true))
+	}
+	return tracer().Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// endDecisionSpan sets decision attributes on span and ends it
+func endDecisionSpan(span trace.Span, decision RateLimiterDecision, err error) {
+	result := "allowed"
+	if !decision.Allowed {
+		result = "denied"
+	}
+	span.SetAttributes(
+		attribute.String("ratelimiter.decision", result),
+		attribute.String("ratelimiter.backend", string(decision.Backend)),
+		attribute.Bool("ratelimiter.degraded", decision.Degraded),
+	)
+	if decision.RetryAfter > 0 {
+		span.SetAttributes(attribute.Int64("ratelimiter.retry_after_ms", decision.RetryAfter.Milliseconds()))
+	}
+	if reason := failReason(err); reason != "" {
+		span.SetAttributes(attribute.String("ratelimiter.fallback_reason", reason))
+	}
+	endSpan(span, err)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,59 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestSpanRecorder(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(provider) })
+	return recorder
+}
+
+func TestGetDecisionForPolicySpans(t *testing.T) {
+	recorder := newTestSpanRecorder(t)
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	// remote cache is missing
+	limiter := newTestPolicyRateLimiter(t, nil)
+
+	_, err := limiter.GetDecisionForPolicy(ctx, "strict", "billingAccount:id1")
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+	parent.End()
+
+	var span sdktrace.ReadOnlySpan
+	for _, ended := range recorder.Ended() {
+		if ended.Name() == "TokenBucketRateLimiter.GetDecision" {
+			span = ended
+		}
+	}
+	assert.NotNil(t, span)
+	assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+	assert.Subset(t, span.Attributes(), []attribute.KeyValue{
+		attribute.String("ratelimiter.policy", "strict"),
+		attribute.String("ratelimiter.decision", "denied"),
+		attribute.Bool("ratelimiter.degraded", true),
+		attribute.Int64("ratelimiter.retry_after_ms", time.Minute.Milliseconds()),
+		attribute.String("ratelimiter.fallback_reason", "backend_unavailable"),
+	})
+}
+
+func TestGetDecisionSpansNoop(t *testing.T) {
+	// no tracer provider is set
+	ctx := context.Background()
+	limiter := newTestPolicyRateLimiter(t, newTestRedisCacheClient(t))
+	decision, err := limiter.GetDecisionForPolicy(ctx, "create", "billingAccount:id1")
+	assert.Nil(t, err)
+	assert.True(t, decision.Allowed)
+	assert.False(t, trace.SpanFromContext(ctx).SpanContext().IsValid())
+}